@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// ApiClient is a typed client for the ultradeck.co API.  Errors are the
+// ones HttpClient returns: *NetworkError, *ClientError or *ServerError.
+type ApiClient struct {
+	HttpClient *HttpClient
+
+	// Context cancels requests, and the retries between them.  Requests
+	// run to the end if it's nil.
+	Context context.Context
+}
+
+func NewApiClient(token string) *ApiClient {
+	return &ApiClient{HttpClient: NewHttpClient(token)}
+}
+
+func (a *ApiClient) Me() (*AuthCheckResponse, error) {
+	bodyBytes, err := a.request("api/v1/auth/me", "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseAuthCheckResponse(bodyBytes)
+}
+
+func (a *ApiClient) ListDecks(username string) ([]*DeckConfig, error) {
+	path := fmt.Sprintf("api/v1/decks?username=%s", url.QueryEscape(username))
+	bodyBytes, err := a.request(path, "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Decks []*DeckConfig `json:"decks"`
+	}
+	if err := decodeResponse(bodyBytes, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Decks, nil
+}
+
+func (a *ApiClient) GetDeck(deckID string, username string) (*DeckConfig, error) {
+	path := fmt.Sprintf("api/v1/decks/%s?username=%s", url.PathEscape(deckID), url.QueryEscape(username))
+	bodyBytes, err := a.request(path, "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDeck(bodyBytes)
+}
+
+func (a *ApiClient) CreateDeck(deck *DeckConfig) (*DeckConfig, error) {
+	body, _ := json.Marshal(&Deck{Config: deck})
+	bodyBytes, err := a.request("api/v1/decks", "POST", body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDeck(bodyBytes)
+}
+
+// clientID identifies this process, so the websocket notification the
+// update causes can be told apart from changes made elsewhere.
+func (a *ApiClient) UpdateDeck(deck *DeckConfig, clientID string) (*DeckConfig, error) {
+	path := fmt.Sprintf("api/v1/decks/%s?client_id=%s", url.PathEscape(deck.UUID), url.QueryEscape(clientID))
+	body, _ := json.Marshal(&Deck{Config: deck})
+	bodyBytes, err := a.request(path, "PUT", body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDeck(bodyBytes)
+}
+
+// PatchDeck sends just the slides that changed, and returns the changes the
+// server made.  Servers that don't support it fail with an error that
+// IsNotSupported recognises; fall back to UpdateDeck then.
+func (a *ApiClient) PatchDeck(deckID string, patch *DeckPatch, clientID string) (*DeckChanges, error) {
+	path := fmt.Sprintf("api/v1/decks/%s/slides?client_id=%s", url.PathEscape(deckID), url.QueryEscape(clientID))
+	body, _ := json.Marshal(patch)
+	bodyBytes, err := a.request(path, "PATCH", body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDeckChanges(bodyBytes)
+}
+
+// returns what changed in the deck since the given updated_at.  Like
+// PatchDeck, older servers don't support it; fall back to GetDeck then.
+func (a *ApiClient) GetDeckChanges(deckID string, since string, username string) (*DeckChanges, error) {
+	path := fmt.Sprintf("api/v1/decks/%s/changes?since=%s&username=%s", url.PathEscape(deckID), url.QueryEscape(since), url.QueryEscape(username))
+	bodyBytes, err := a.request(path, "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDeckChanges(bodyBytes)
+}
+
+func (a *ApiClient) DeleteDeck(deckID string) error {
+	path := fmt.Sprintf("api/v1/decks/%s", url.PathEscape(deckID))
+	_, err := a.request(path, "DELETE", nil)
+	return err
+}
+
+func (a *ApiClient) GetAwsCreds() (*AwsCreds, error) {
+	bodyBytes, err := a.request("api/v1/auth/aws_creds", "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	awsCreds := &AwsCreds{}
+	if err := decodeResponse(bodyBytes, awsCreds); err != nil {
+		return nil, err
+	}
+	return awsCreds, nil
+}
+
+// exchanges the token for a one-time code, for links opened in the browser
+func (a *ApiClient) CreateLoginCode() (*LoginCode, error) {
+	bodyBytes, err := a.request("api/v1/auth/login_codes", "POST", []byte("{}"))
+	if err != nil {
+		return nil, err
+	}
+	return parseLoginCode(bodyBytes)
+}
+
+func (a *ApiClient) request(path string, verb string, body []byte) ([]byte, error) {
+	if body == nil {
+		body = []byte("")
+	}
+	ctx := a.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	bodyBytes, _, err := a.HttpClient.PerformRequestContext(ctx, path, verb, body)
+	return bodyBytes, err
+}
+
+func decodeDeck(bodyBytes []byte) (*DeckConfig, error) {
+	deck := &DeckConfig{}
+	if err := decodeResponse(bodyBytes, deck); err != nil {
+		return nil, err
+	}
+	return deck, nil
+}
+
+func decodeDeckChanges(bodyBytes []byte) (*DeckChanges, error) {
+	changes := &DeckChanges{}
+	if err := decodeResponse(bodyBytes, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func decodeResponse(bodyBytes []byte, v interface{}) error {
+	if err := json.Unmarshal(bodyBytes, v); err != nil {
+		return fmt.Errorf("unexpected response from ultradeck.co: %s", truncateBody(bodyBytes))
+	}
+	return nil
+}
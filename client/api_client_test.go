@@ -0,0 +1,263 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeDeck(t *testing.T) {
+	assert := assert.New(t)
+
+	deck, err := decodeDeck([]byte(`{"uuid":"some-uuid","title":"Testing","slides_attributes":[{"markdown":"# Slide 1"}]}`))
+
+	assert.Nil(err)
+	assert.Equal("Testing", deck.Title)
+	assert.Equal("# Slide 1", deck.Slides[0].Markdown)
+
+	deck, err = decodeDeck([]byte("oops"))
+	assert.Nil(deck)
+	assert.EqualError(err, "unexpected response from ultradeck.co: oops")
+}
+
+func TestPatchDeck(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("PATCH", r.Method)
+		assert.Equal("/api/v1/decks/deck-uuid/slides", r.URL.Path)
+		assert.Equal("client-1", r.URL.Query().Get("client_id"))
+
+		patch := &DeckPatch{}
+		json.NewDecoder(r.Body).Decode(patch)
+		assert.Equal("2018-01-01T00:00:00.000Z", patch.BaseUpdatedAt)
+		assert.Equal(RemoveSlideOp, patch.Ops[0].Op)
+
+		w.Write([]byte(`{"updated_at":"2018-01-02T00:00:00.000Z","ops":[{"op":"remove","uuid":"a"}]}`))
+	}))
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	patch := &DeckPatch{BaseUpdatedAt: "2018-01-01T00:00:00.000Z", Ops: []*SlideOp{{Op: RemoveSlideOp, UUID: "a"}}}
+	changes, err := apiClient.PatchDeck("deck-uuid", patch, "client-1")
+
+	assert.Nil(err)
+	assert.Equal("2018-01-02T00:00:00.000Z", changes.UpdatedAt)
+	assert.Equal("a", changes.Ops[0].UUID)
+}
+
+func TestPatchDeckNotSupported(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	_, err := apiClient.PatchDeck("deck-uuid", &DeckPatch{}, "client-1")
+
+	assert.True(IsNotSupported(err))
+}
+
+// a test server that checks the request and answers with status and body
+func newTestApiServer(t *testing.T, verb string, requestURI string, status int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, verb, r.Method)
+		assert.Equal(t, requestURI, r.URL.RequestURI())
+		assert.Equal(t, "Bearer abcd1234", r.Header.Get("Authorization"))
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+}
+
+func TestMe(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "GET", "/api/v1/auth/me", 200, `{"is_signed_in":true,"uuid":"user-uuid","username":"grant"}`)
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	me, err := apiClient.Me()
+
+	assert.Nil(err)
+	assert.Equal("grant", me.Username)
+}
+
+func TestMeUnreachable(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	httpClient := newTestHttpClient(server.URL)
+	httpClient.MaxRetries = -1
+	apiClient := &ApiClient{HttpClient: httpClient}
+	_, err := apiClient.Me()
+
+	assert.IsType(&NetworkError{}, err)
+}
+
+func TestListDecks(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "GET", "/api/v1/decks?username=grant+g%26co", 200, `{"decks":[{"uuid":"deck-1","title":"One"},{"uuid":"deck-2","title":"Two"}]}`)
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	decks, err := apiClient.ListDecks("grant g&co")
+
+	assert.Nil(err)
+	assert.Equal(2, len(decks))
+	assert.Equal("Two", decks[1].Title)
+}
+
+func TestGetDeck(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "GET", "/api/v1/decks/deck%2F1?username=grant", 200, `{"uuid":"deck/1","title":"Testing"}`)
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	deck, err := apiClient.GetDeck("deck/1", "grant")
+
+	assert.Nil(err)
+	assert.Equal("Testing", deck.Title)
+}
+
+func TestGetDeckNotFound(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "GET", "/api/v1/decks/deck-uuid?username=grant", 404, `{"error":"not found"}`)
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	deck, err := apiClient.GetDeck("deck-uuid", "grant")
+
+	assert.Nil(deck)
+	assert.True(IsNotFound(err))
+}
+
+func TestCreateDeck(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("POST", r.Method)
+		assert.Equal("/api/v1/decks", r.URL.RequestURI())
+
+		deck := &Deck{}
+		json.NewDecoder(r.Body).Decode(deck)
+		assert.Equal("Testing", deck.Config.Title)
+
+		w.Write([]byte(`{"uuid":"new-uuid","title":"Testing"}`))
+	}))
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	deck, err := apiClient.CreateDeck(&DeckConfig{Title: "Testing"})
+
+	assert.Nil(err)
+	assert.Equal("new-uuid", deck.UUID)
+}
+
+func TestCreateDeckRejected(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "POST", "/api/v1/decks", 422, `{"error":"you have too many private decks"}`)
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	_, err := apiClient.CreateDeck(&DeckConfig{Title: "Testing"})
+
+	assert.Equal(&ClientError{StatusCode: 422, Message: "you have too many private decks"}, err)
+}
+
+func TestUpdateDeck(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("PUT", r.Method)
+		assert.Equal("/api/v1/decks/deck-uuid?client_id=client+1", r.URL.RequestURI())
+
+		deck := &Deck{}
+		json.NewDecoder(r.Body).Decode(deck)
+		assert.Equal("deck-uuid", deck.Config.UUID)
+
+		w.Write([]byte(`{"uuid":"deck-uuid","updated_at":"2018-01-02T00:00:00.000Z"}`))
+	}))
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	deck, err := apiClient.UpdateDeck(&DeckConfig{UUID: "deck-uuid"}, "client 1")
+
+	assert.Nil(err)
+	assert.Equal("2018-01-02T00:00:00.000Z", deck.UpdatedAt)
+}
+
+func TestUpdateDeckConflict(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "PUT", "/api/v1/decks/deck-uuid?client_id=client-1", 409, `{"error":"the deck changed"}`)
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	_, err := apiClient.UpdateDeck(&DeckConfig{UUID: "deck-uuid"}, "client-1")
+
+	assert.True(IsConflict(err))
+}
+
+func TestDeleteDeck(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "DELETE", "/api/v1/decks/deck-uuid", 204, "")
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	assert.Nil(apiClient.DeleteDeck("deck-uuid"))
+}
+
+func TestDeleteDeckServerError(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "DELETE", "/api/v1/decks/deck-uuid", 500, "oops")
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	err := apiClient.DeleteDeck("deck-uuid")
+
+	assert.IsType(&ServerError{}, err)
+}
+
+func TestGetAwsCreds(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "GET", "/api/v1/auth/aws_creds", 200, `{"access_key_id":"key","secret_access_key":"secret","session_token":"session"}`)
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	creds, err := apiClient.GetAwsCreds()
+
+	assert.Nil(err)
+	assert.Equal(&AwsCreds{AccessKeyID: "key", SecretAccessKey: "secret", SessionToken: "session"}, creds)
+}
+
+func TestGetAwsCredsUnexpectedResponse(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "GET", "/api/v1/auth/aws_creds", 200, "<html>")
+	defer server.Close()
+
+	apiClient := &ApiClient{HttpClient: newTestHttpClient(server.URL)}
+	_, err := apiClient.GetAwsCreds()
+
+	assert.EqualError(err, "unexpected response from ultradeck.co: <html>")
+}
+
+func TestCancellingStopsRetries(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestApiServer(t, "GET", "/api/v1/decks/deck-1?username=grant", 503, `{"error":"down for maintenance"}`)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	httpClient := &HttpClient{Token: "abcd1234", BaseURL: server.URL, MaxRetries: 10, RetryBaseDelay: time.Minute}
+	apiClient := &ApiClient{HttpClient: httpClient, Context: ctx}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	started := time.Now()
+	_, err := apiClient.GetDeck("deck-1", "grant")
+	assert.True(IsNetworkError(err))
+	assert.True(time.Since(started) < 5*time.Second, "gave up on the retries")
+}
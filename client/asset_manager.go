@@ -1,26 +1,51 @@
 package client
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"mime"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/twinj/uuid"
 )
 
-type AssetManager struct{}
+type AssetManager struct {
+	// Dir is the deck's directory; the working directory if empty.  Asset
+	// file names are relative to it.
+	Dir string
+
+	// UploadProgress, if set, is told when each upload starts and finishes
+	UploadProgress func(fileName string, finished bool)
+
+	// ConfirmRemoval decides whether an asset that's on ultradeck.co, but
+	// not here any more, is taken out of the deck.  If it's nil, the asset
+	// stays.
+	ConfirmRemoval func(fileName string) bool
+
+	// Out is where progress and problems are reported; stdout if nil
+	Out io.Writer
+
+	// Writes, if set, is told about each download before it's written
+	Writes *WriteTracker
+
+	// Context cancels uploads and downloads; nil for ones that run to the
+	// end
+	Context context.Context
+}
 
 type AwsCreds struct {
 	AccessKeyID     string `json:"access_key_id"`
@@ -28,9 +53,15 @@ type AwsCreds struct {
 	SessionToken    string `json:"session_token"`
 }
 
-func (a *AssetManager) PushLocalAssets(token string, deckConfig *DeckConfig) *DeckConfig {
-	localFiles := a.readFiles()
-	uploader := a.setupUploader(token)
+func (a *AssetManager) PushLocalAssets(token string, deckConfig *DeckConfig) (*DeckConfig, error) {
+	localFiles, err := a.readFiles()
+	if err != nil {
+		return deckConfig, err
+	}
+
+	// only ask for upload credentials if there's something to upload, so
+	// pushing slide changes works without them
+	var uploader *s3manager.Uploader
 	for _, fileName := range localFiles {
 		var found bool
 		for _, asset := range deckConfig.Assets {
@@ -42,35 +73,67 @@ func (a *AssetManager) PushLocalAssets(token string, deckConfig *DeckConfig) *De
 		}
 
 		if !found {
-			fmt.Printf("Uploading %s\n", fileName)
-			asset := a.uploadFile(fileName, uploader)
+			if uploader == nil {
+				var err error
+				if uploader, err = a.setupUploader(token); err != nil {
+					return deckConfig, err
+				}
+			}
+			fmt.Fprintf(a.out(), "Uploading %s\n", fileName)
+			a.reportUpload(fileName, false)
+			asset, err := a.uploadFile(fileName, uploader)
+			a.reportUpload(fileName, true)
+			if err != nil {
+				return deckConfig, err
+			}
 			deckConfig.Assets = append(deckConfig.Assets, asset)
 		}
 	}
 
 	// handle the case where there is a remote asset that is not local
-	for i, asset := range deckConfig.Assets {
+	// a new slice, as Assets may be shared; nil if Assets is
+	kept := deckConfig.Assets[:0:0]
+	for _, asset := range deckConfig.Assets {
 		var found bool
 		for _, fileName := range localFiles {
 			if asset.Filename == fileName {
 				found = true
 			}
 		}
-		if !found {
-			fmt.Printf("The file %s exists on app.ultradeck.co, but not locally.  Do you want to delete it from your deck? (y/n) ", asset.Filename)
-			reader := bufio.NewReader(os.Stdin)
-			name, _ := reader.ReadString('\n')
-			if name == "y\n" {
-				deckConfig.Assets = append(deckConfig.Assets[:i], deckConfig.Assets[i+1:]...)
-			}
-
+		if !found && a.ConfirmRemoval != nil && a.ConfirmRemoval(asset.Filename) {
+			continue
 		}
+		kept = append(kept, asset)
+	}
+	deckConfig.Assets = kept
+	return deckConfig, nil
+}
+
+func (a *AssetManager) context() context.Context {
+	if a.Context != nil {
+		return a.Context
+	}
+	return context.Background()
+}
+
+func (a *AssetManager) out() io.Writer {
+	if a.Out != nil {
+		return a.Out
 	}
-	return deckConfig
+	return os.Stdout
 }
 
-func (a *AssetManager) PullRemoteAssets(deckConfig *DeckConfig) {
-	localFiles := a.readFiles()
+func (a *AssetManager) reportUpload(fileName string, finished bool) {
+	if a.UploadProgress != nil {
+		a.UploadProgress(fileName, finished)
+	}
+}
+
+func (a *AssetManager) PullRemoteAssets(deckConfig *DeckConfig) error {
+	localFiles, err := a.readFiles()
+	if err != nil {
+		return err
+	}
 	for _, asset := range deckConfig.Assets {
 		var found bool
 		for _, fileName := range localFiles {
@@ -82,46 +145,73 @@ func (a *AssetManager) PullRemoteAssets(deckConfig *DeckConfig) {
 		}
 
 		if !found {
-			fmt.Println("Downloading ", asset.Filename)
+			fmt.Fprintln(a.out(), "Downloading ", asset.Filename)
 			a.downloadFile(asset)
 		}
 	}
+	return nil
 }
 
-func (a *AssetManager) setupUploader(token string) *s3manager.Uploader {
-	httpClient := NewHttpClient(token)
-	jsonData := httpClient.GetRequest("/api/v1/auth/aws_creds")
-	awsCreds := &AwsCreds{}
-	json.Unmarshal(jsonData, awsCreds)
+func (a *AssetManager) setupUploader(token string) (*s3manager.Uploader, error) {
+	apiClient := NewApiClient(token)
+	apiClient.Context = a.Context
+	awsCreds, err := apiClient.GetAwsCreds()
+	if err != nil {
+		return nil, err
+	}
 
 	sess := session.Must(session.NewSession(&aws.Config{
 		Region:      aws.String(endpoints.UsEast1RegionID),
 		Credentials: credentials.NewStaticCredentials(awsCreds.AccessKeyID, awsCreds.SecretAccessKey, awsCreds.SessionToken),
+		HTTPClient:  &http.Client{Transport: CurrentEndpoints().Transport()},
 	}))
-	return s3manager.NewUploader(sess)
+	return s3manager.NewUploader(sess), nil
 }
 
 func (a *AssetManager) downloadFile(asset *Asset) {
-	resp, err := http.Get(asset.URL)
+	fileName := filepath.FromSlash(asset.Filename)
+	if filepath.IsAbs(fileName) || strings.HasPrefix(filepath.Clean(fileName), "..") {
+		fmt.Fprintln(a.out(), "Not downloading asset outside the deck directory: ", asset.Filename)
+		return
+	}
+
+	// through the configured proxy, trusting the configured CA bundle, like
+	// requests to the API
+	client := &http.Client{Transport: CurrentEndpoints().Transport()}
+	req, err := http.NewRequest("GET", asset.URL, nil)
 	if err != nil {
-		fmt.Println("Error downloading asset: ", err)
+		fmt.Fprintln(a.out(), "Error downloading asset: ", err)
+		return
+	}
+	resp, err := client.Do(req.WithContext(a.context()))
+	if err != nil {
+		fmt.Fprintln(a.out(), "Error downloading asset: ", err)
 		return
 	}
 
 	body, _ := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(a.out(), "Error downloading asset %s: %s\n", asset.Filename, resp.Status)
+		return
+	}
 
-	if err := ioutil.WriteFile(asset.Filename, body, 0644); err != nil {
-		fmt.Println("Couldn't write file "+asset.Filename, err)
+	path := filepath.Join(a.Dir, fileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Fprintln(a.out(), "Couldn't create directory for "+asset.Filename, err)
+		return
+	}
+	if err := a.Writes.WriteFile(path, body); err != nil {
+		fmt.Fprintln(a.out(), "Couldn't write file "+asset.Filename, err)
 	}
 }
 
-func (a *AssetManager) uploadFile(fileName string, uploader *s3manager.Uploader) *Asset {
+func (a *AssetManager) uploadFile(fileName string, uploader *s3manager.Uploader) (*Asset, error) {
 	keyName := fmt.Sprintf("/uploads/%s/%s", uuid.NewV4(), fileName)
 
-	file, err := os.Open(fileName)
+	file, err := os.Open(filepath.Join(a.Dir, filepath.FromSlash(fileName)))
 	if err != nil {
-		fmt.Printf("err opening file: %s", err)
+		return nil, fmt.Errorf("could not open %s: %s", fileName, err)
 	}
 	defer file.Close()
 
@@ -143,13 +233,34 @@ func (a *AssetManager) uploadFile(fileName string, uploader *s3manager.Uploader)
 		ContentType: &mimeType,
 	}
 
-	result, err := uploader.Upload(upParams)
+	result, err := uploader.UploadWithContext(a.context(), upParams)
 	if err != nil {
-		fmt.Println("error uploading file: ", err)
+		uploadErr := fmt.Errorf("uploading %s: %s", fileName, err)
+		if isTransportError(err) {
+			return nil, &NetworkError{Err: uploadErr}
+		}
+		return nil, uploadErr
 	}
 
 	asset := &Asset{Filename: fileName, URL: result.Location}
-	return asset
+	return asset, nil
+}
+
+// true if err means S3 couldn't be reached, rather than that it refused the
+// upload, e.g. with AccessDenied or expired credentials, which trying again
+// won't fix
+func isTransportError(err error) bool {
+	switch err := err.(type) {
+	case net.Error:
+		return true
+	case awserr.Error:
+		switch err.Code() {
+		case "RequestError", request.ErrCodeResponseTimeout, request.ErrCodeRead:
+			return true
+		}
+		return isTransportError(err.OrigErr())
+	}
+	return false
 }
 
 func (a *AssetManager) getBucketName() string {
@@ -159,22 +270,55 @@ func (a *AssetManager) getBucketName() string {
 	return "ultradeck-assets-prod"
 }
 
-func (a *AssetManager) readFiles() []string {
-	files, err := ioutil.ReadDir(".")
-	if err != nil {
-		log.Fatal("Error reading directory: ", err)
+// finds the images in the deck directory and the directories under it.
+// Nested ones are named by their slash-separated path, e.g. images/cat.png.
+func (a *AssetManager) readFiles() ([]string, error) {
+	var ret []string
+	root := a.Dir
+	if root == "" {
+		root = "."
 	}
+	ignore := LoadIgnoreRulesOrDefault(root, a.out())
 
-	var ret []string
+	err := filepath.Walk(root, func(walked string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// removed while we were walking, e.g. an editor's temp file
+			return nil
+		}
+		if walked == root {
+			return err
+		}
+		path, relErr := filepath.Rel(root, walked)
+		if relErr != nil {
+			return relErr
+		}
+		isDir := info != nil && info.IsDir()
+		if ignore.Ignored(path, isDir) {
+			if isDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 
-	for _, file := range files {
 		// TODO: support more extension types?
-		if strings.HasPrefix(a.mimeType(file.Name()), "image") {
-			ret = append(ret, file.Name())
+		if !isDir && IsAsset(path) {
+			ret = append(ret, filepath.ToSlash(path))
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not look for assets: %s", err)
 	}
 
-	return ret
+	return ret, nil
+}
+
+// IsAsset is true for the files push uploads as assets, i.e. images
+func IsAsset(fileName string) bool {
+	return strings.HasPrefix(mime.TypeByExtension(filepath.Ext(fileName)), "image")
 }
 
 func (a *AssetManager) mimeType(fileName string) string {
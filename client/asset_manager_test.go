@@ -0,0 +1,153 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFilesFindsNestedImages(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "assets")
+	defer os.RemoveAll(dir)
+
+	for _, path := range []string{"cat.png", "deck.md", "images/dog.jpg", "images/raw/notes.txt", "drafts/old.png", "node_modules/pkg/logo.png"} {
+		path = filepath.Join(dir, path)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte("x"), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(dir, IgnoreFile), []byte("drafts/\n"), 0644)
+
+	assetManager := &AssetManager{Dir: dir}
+	files, err := assetManager.readFiles()
+	assert.Nil(err)
+	assert.Equal([]string{"cat.png", "images/dog.jpg"}, files)
+}
+
+func TestReadFilesUnreadableDirectory(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("root can read anything")
+	}
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "assets")
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	os.Mkdir("private", 0000)
+	defer os.Chmod("private", 0755)
+
+	assetManager := &AssetManager{}
+	_, err := assetManager.readFiles()
+	assert.NotNil(err)
+
+	ioutil.WriteFile(IgnoreFile, []byte("private/\n"), 0644)
+	_, err = assetManager.readFiles()
+	assert.Nil(err, "ignored directories aren't read")
+}
+
+func TestIsAsset(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(IsAsset("cat.png"))
+	assert.True(IsAsset("images/dog.JPG"))
+	assert.False(IsAsset("deck.md"))
+	assert.False(IsAsset("slides/intro.md"))
+	assert.False(IsAsset("4913"))
+}
+
+func TestDownloadFileUsesTheConfiguredProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("http://assets.example.com/cat.png", r.URL.String())
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+
+	endpoints, err := LoadEndpoints(Endpoints{Proxy: proxy.URL})
+	assert.Nil(err)
+	SetEndpoints(endpoints)
+	defer SetEndpoints(nil)
+
+	dir, _ := ioutil.TempDir("", "assets")
+	defer os.RemoveAll(dir)
+
+	assetManager := &AssetManager{Dir: dir}
+	assetManager.downloadFile(&Asset{Filename: "images/cat.png", URL: "http://assets.example.com/cat.png"})
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "images", "cat.png"))
+	assert.Nil(err)
+	assert.Equal("via proxy", string(data))
+}
+
+func newTestUploader(endpoint string) *s3manager.Uploader {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:         aws.String(endpoint),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	return s3manager.NewUploader(sess)
+}
+
+func TestUploadFileOnlyCountsUnreachableS3AsOffline(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "assets")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "cat.png"), []byte("not really a png"), 0644)
+	assetManager := &AssetManager{Dir: dir}
+
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+	}))
+	_, err := assetManager.uploadFile("cat.png", newTestUploader(s3.URL))
+	assert.NotNil(err)
+	assert.False(IsNetworkError(err), "trying again won't help")
+	assert.Contains(err.Error(), "AccessDenied")
+
+	s3.Close()
+	_, err = assetManager.uploadFile("cat.png", newTestUploader(s3.URL))
+	assert.True(IsNetworkError(err))
+}
+
+func TestPushLocalAssetsOnlyRemovesConfirmedAssets(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "assets")
+	defer os.RemoveAll(dir)
+	remote := func() *DeckConfig {
+		return &DeckConfig{Assets: []*Asset{{Filename: "cat.png"}, {Filename: "dog.png"}}}
+	}
+
+	assetManager := &AssetManager{Dir: dir}
+	deckConfig, err := assetManager.PushLocalAssets("token", remote())
+	assert.Nil(err)
+	assert.Equal(2, len(deckConfig.Assets), "nobody to ask, so both stay")
+
+	var asked []string
+	assetManager.ConfirmRemoval = func(fileName string) bool {
+		asked = append(asked, fileName)
+		return fileName == "dog.png"
+	}
+	deckConfig, err = assetManager.PushLocalAssets("token", remote())
+	assert.Nil(err)
+	assert.Equal([]string{"cat.png", "dog.png"}, asked)
+	assert.Equal(1, len(deckConfig.Assets))
+	assert.Equal("cat.png", deckConfig.Assets[0].Filename)
+}
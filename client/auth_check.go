@@ -1,27 +1,64 @@
 package client
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 type AuthCheck struct{}
 
 type AuthCheckResponse struct {
-	IsSignedIn       bool   `json:"is_signed_in"`
-	UUID             string `json:"uuid"`
-	Name             string `json:"name"`
-	Username         string `json:"username"`
-	ImageUrl         string `json:"image_url"`
-	Email            string `json:"email"`
-	SubscriptionName string `json:"subscriptionName"`
-	Token            string
+	IsSignedIn       bool        `json:"is_signed_in"`
+	UUID             string      `json:"uuid"`
+	Name             string      `json:"name"`
+	Username         string      `json:"username"`
+	ImageUrl         string      `json:"image_url"`
+	Email            string      `json:"email"`
+	SubscriptionName string      `json:"subscriptionName"`
+	Limits           *PlanLimits `json:"limits"`
+	Token            string      `json:"-"`
 }
 
-func (a *AuthCheck) CheckAuth(token string) *AuthCheckResponse {
+// usage and limits of the user's plan.  A limit of 0 means unlimited.
+type PlanLimits struct {
+	PrivateDecks      int   `json:"private_decks"`
+	PrivateDecksLimit int   `json:"private_decks_limit"`
+	AssetStorageUsed  int64 `json:"asset_storage_used"`
+	AssetStorageLimit int64 `json:"asset_storage_limit"`
+}
+
+func (a *AuthCheck) CheckAuth(token string) (*AuthCheckResponse, error) {
 	httpClient := NewHttpClient(token)
-	bodyBytes := httpClient.GetRequest("api/v1/auth/me")
+	bodyBytes, _, err := httpClient.GetRequest("api/v1/auth/me")
+	if IsUnauthorized(err) {
+		return &AuthCheckResponse{IsSignedIn: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseAuthCheckResponse(bodyBytes)
+}
 
+// decodes the body of api/v1/auth/me.  A signed-in response must at least
+// carry the user's uuid and username, since later commands depend on them.
+func parseAuthCheckResponse(bodyBytes []byte) (*AuthCheckResponse, error) {
 	resp := &AuthCheckResponse{}
-	if err := json.Unmarshal(bodyBytes, &resp); err != nil {
-		panic(err)
+	if err := json.Unmarshal(bodyBytes, resp); err != nil {
+		return nil, fmt.Errorf("unexpected response from ultradeck.co: %s", truncateBody(bodyBytes))
+	}
+
+	if resp.IsSignedIn && (resp.UUID == "" || resp.Username == "") {
+		return nil, fmt.Errorf("auth response from ultradeck.co is missing user details")
+	}
+	return resp, nil
+}
+
+// keeps error messages readable when the server answers with an HTML page
+func truncateBody(bodyBytes []byte) string {
+	const maxLen = 120
+	if len(bodyBytes) > maxLen {
+		return string(bodyBytes[:maxLen]) + "..."
 	}
-	return resp
+	return string(bodyBytes)
 }
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// how long a successful auth check is trusted before asking the backend again
+const AuthCheckTTL = time.Hour
+
+// AuthCheckCache stores the last AuthCheckResponse next to auth.json, so
+// commands don't need a round trip to api/v1/auth/me every time they run.
+// It's only about the token that was checked; asking with another, e.g.
+// from ULTRADECK_TOKEN, misses.
+type AuthCheckCache struct{}
+
+type cachedAuthCheck struct {
+	Response  *AuthCheckResponse `json:"response"`
+	CheckedAt time.Time          `json:"checked_at"`
+	TokenHash string             `json:"token_hash"`
+}
+
+// returns the cached response for token, or nil if there is none or it has
+// expired
+func (a *AuthCheckCache) Read(token string) *AuthCheckResponse {
+	cached := a.read(token)
+	if cached == nil || time.Since(cached.CheckedAt) > AuthCheckTTL {
+		return nil
+	}
+	return cached.Response
+}
+
+// like Read, but also returns an expired response.  For when the backend
+// can't be reached to check again.
+func (a *AuthCheckCache) ReadStale(token string) *AuthCheckResponse {
+	cached := a.read(token)
+	if cached == nil {
+		return nil
+	}
+	return cached.Response
+}
+
+func (a *AuthCheckCache) read(token string) *cachedAuthCheck {
+	data, err := ioutil.ReadFile(a.cacheFileLocation())
+	if err != nil {
+		return nil
+	}
+
+	var cached cachedAuthCheck
+	if err := json.Unmarshal(data, &cached); err != nil {
+		DebugMsg("ignoring unreadable auth check cache")
+		return nil
+	}
+
+	if cached.Response == nil || cached.TokenHash != tokenHash(token) {
+		return nil
+	}
+	return &cached
+}
+
+// caches resp as the result of checking token
+func (a *AuthCheckCache) Write(token string, resp *AuthCheckResponse) {
+	a.write(token, resp, time.Now())
+}
+
+func (a *AuthCheckCache) write(token string, resp *AuthCheckResponse, checkedAt time.Time) {
+	data, _ := json.Marshal(&cachedAuthCheck{Response: resp, CheckedAt: checkedAt, TokenHash: tokenHash(token)})
+
+	authConfig := &AuthConfig{}
+	if err := os.MkdirAll(authConfig.configFilePath(), os.ModePerm); err != nil {
+		log.Println("Error creating config directory", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(a.cacheFileLocation(), data, 0600); err != nil {
+		log.Println("Error writing auth check cache", err)
+	}
+}
+
+func (a *AuthCheckCache) Remove() {
+	if err := os.Remove(a.cacheFileLocation()); err != nil && !os.IsNotExist(err) {
+		log.Println("Error removing auth check cache", err)
+	}
+}
+
+// what the cache keeps to tell tokens apart, rather than a second copy of
+// the token
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *AuthCheckCache) cacheFileLocation() string {
+	authConfig := &AuthConfig{}
+	return authConfig.configFilePath() + "auth_check.json"
+}
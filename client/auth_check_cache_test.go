@@ -0,0 +1,50 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthCheckCacheReadWrite(t *testing.T) {
+	assert := assert.New(t)
+	cache := &AuthCheckCache{}
+	cache.Remove()
+
+	assert.Nil(cache.Read("abcd1234"))
+
+	cache.Write("abcd1234", &AuthCheckResponse{IsSignedIn: true, Username: "gammons", Token: "abcd1234"})
+	resp := cache.Read("abcd1234")
+
+	assert.Equal("gammons", resp.Username)
+	assert.Equal("", resp.Token)
+
+	cache.Remove()
+	assert.Nil(cache.Read("abcd1234"))
+}
+
+func TestAuthCheckCacheExpired(t *testing.T) {
+	assert := assert.New(t)
+	cache := &AuthCheckCache{}
+
+	cache.write("abcd1234", &AuthCheckResponse{IsSignedIn: true, Username: "gammons"}, time.Now().Add(-2*AuthCheckTTL))
+
+	assert.Nil(cache.Read("abcd1234"))
+	assert.Equal("gammons", cache.ReadStale("abcd1234").Username)
+	cache.Remove()
+	assert.Nil(cache.ReadStale("abcd1234"))
+}
+
+func TestAuthCheckCacheIsForOneToken(t *testing.T) {
+	assert := assert.New(t)
+	cache := &AuthCheckCache{}
+	defer cache.Remove()
+
+	cache.write("from-auth-json", &AuthCheckResponse{IsSignedIn: true, Username: "gammons"}, time.Now().Add(-2*AuthCheckTTL))
+	assert.Nil(cache.ReadStale("from-env"), "another user's token")
+
+	cache.Write("from-auth-json", &AuthCheckResponse{IsSignedIn: true, Username: "gammons"})
+	assert.Nil(cache.Read("from-env"))
+	assert.Equal("gammons", cache.Read("from-auth-json").Username)
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAuthCheckResponse(t *testing.T) {
+	assert := assert.New(t)
+	body := []byte(`{"is_signed_in":true,"uuid":"some-uuid","username":"gammons","name":"Grant"}`)
+
+	resp, err := parseAuthCheckResponse(body)
+
+	assert.Nil(err)
+	assert.Equal(true, resp.IsSignedIn)
+	assert.Equal("gammons", resp.Username)
+}
+
+func TestParseAuthCheckResponseSignedOut(t *testing.T) {
+	assert := assert.New(t)
+
+	resp, err := parseAuthCheckResponse([]byte(`{"is_signed_in":false}`))
+
+	assert.Nil(err)
+	assert.Equal(false, resp.IsSignedIn)
+}
+
+func TestParseAuthCheckResponseNotJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	resp, err := parseAuthCheckResponse([]byte("<html><body>502 Bad Gateway</body></html>"))
+
+	assert.Nil(resp)
+	assert.EqualError(err, "unexpected response from ultradeck.co: <html><body>502 Bad Gateway</body></html>")
+}
+
+func TestParseAuthCheckResponseMissingUser(t *testing.T) {
+	assert := assert.New(t)
+
+	resp, err := parseAuthCheckResponse([]byte(`{"is_signed_in":true,"username":null}`))
+
+	assert.Nil(resp)
+	assert.NotNil(err)
+}
@@ -1,14 +1,35 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"os/user"
+	"strings"
+	"time"
 )
 
+const (
+	// overrides the token in auth.json, e.g. for CI
+	TokenEnvVar = "ULTRADECK_TOKEN"
+
+	// selects which account's auth.json to use
+	ProfileEnvVar  = "ULTRADECK_PROFILE"
+	DefaultProfile = "default"
+)
+
+// tokens that expire within this window are refreshed ahead of time
+const TokenRefreshWindow = 5 * time.Minute
+
+// returned by Refresh when there's no refresh token, or the backend doesn't
+// support refreshing.  The user has to sign in again through the browser.
+var ErrRefreshNotSupported = errors.New("token refresh is not supported")
+
 type AuthConfig struct {
 	AuthJson *AuthJson
 }
@@ -21,20 +42,90 @@ type AuthJson struct {
 	ImageUrl         string `json:"image_url"`
 	Email            string `json:"email"`
 	SubscriptionName string `json:"subscription_name"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	ExpiresAt        string `json:"expires_at,omitempty"`
 }
 
-func NewAuthConfig(response map[string]interface{}) *AuthConfig {
-	authJson := &AuthJson{
-		Token:            response["token"].(string),
-		UUID:             response["uuid"].(string),
-		Username:         response["username"].(string),
-		Name:             response["name"].(string),
-		ImageUrl:         response["image_url"].(string),
-		Email:            response["email"].(string),
-		SubscriptionName: response["subscription_name"].(string),
+type refreshTokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    string `json:"expires_at"`
+}
+
+func NewAuthConfig(response map[string]interface{}) (*AuthConfig, error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("could not read auth response: %s", err)
 	}
+	return NewAuthConfigFromJSON(data)
+}
 
-	return &AuthConfig{AuthJson: authJson}
+// like NewAuthConfig, for the raw data of an auth_complete message
+func NewAuthConfigFromJSON(data []byte) (*AuthConfig, error) {
+	authJson := &AuthJson{}
+	if err := json.Unmarshal(data, authJson); err != nil {
+		return nil, fmt.Errorf("could not read auth response: %s", err)
+	}
+
+	if err := authJson.validate(); err != nil {
+		return nil, err
+	}
+
+	return &AuthConfig{AuthJson: authJson}, nil
+}
+
+// ensures the fields every authorized command depends on are present
+func (a *AuthJson) validate() error {
+	var missing []string
+	if a.Token == "" {
+		missing = append(missing, "token")
+	}
+	if a.UUID == "" {
+		missing = append(missing, "uuid")
+	}
+	if a.Username == "" {
+		missing = append(missing, "username")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("auth response is missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// returns when the token expires.  ok is false if the backend didn't tell us.
+func (a *AuthJson) Expiry() (expiresAt time.Time, ok bool) {
+	if a.ExpiresAt == "" {
+		return time.Time{}, false
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, a.ExpiresAt)
+	if err != nil {
+		DebugMsg("could not parse token expiry " + a.ExpiresAt)
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+// true if the token has expired, or is about to
+func (a *AuthJson) ExpiresSoon() bool {
+	expiresAt, ok := a.Expiry()
+	return ok && time.Until(expiresAt) < TokenRefreshWindow
+}
+
+// builds an AuthCheckResponse from what was saved at sign-in, for commands
+// that don't need to ask the backend who the user is.
+func (a *AuthJson) ToAuthCheckResponse() *AuthCheckResponse {
+	return &AuthCheckResponse{
+		IsSignedIn:       true,
+		UUID:             a.UUID,
+		Name:             a.Name,
+		Username:         a.Username,
+		ImageUrl:         a.ImageUrl,
+		Email:            a.Email,
+		SubscriptionName: a.SubscriptionName,
+		Token:            a.Token,
+	}
 }
 
 func (c *AuthConfig) AuthFileExists() bool {
@@ -44,20 +135,27 @@ func (c *AuthConfig) AuthFileExists() bool {
 	return true
 }
 
-func (c *AuthConfig) WriteAuth() {
+// writes auth.json.  It's replaced in one go, so a failed write leaves the
+// old one alone.
+func (c *AuthConfig) WriteAuth() error {
 	data, _ := json.Marshal(c.AuthJson)
 
-	if c.AuthFileExists() {
-		c.RemoveAuthFile()
-	}
-
 	if err := os.MkdirAll(c.configFilePath(), os.ModePerm); err != nil {
-		os.Exit(1)
+		return fmt.Errorf("could not write %s: %s", c.configFileLocation(), err)
 	}
 
-	if err := ioutil.WriteFile(c.configFileLocation(), []byte(data), 0644); err != nil {
-		log.Println("Error writing json file", err)
+	tmpFile := c.configFileLocation() + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %s", c.configFileLocation(), err)
 	}
+	if err := os.Rename(tmpFile, c.configFileLocation()); err != nil {
+		return fmt.Errorf("could not write %s: %s", c.configFileLocation(), err)
+	}
+
+	// whatever we knew about the old token no longer applies
+	authCache := &AuthCheckCache{}
+	authCache.Remove()
+	return nil
 }
 
 func (c *AuthConfig) ReadConfig() *AuthJson {
@@ -79,23 +177,118 @@ func (c *AuthConfig) ReadConfig() *AuthJson {
 }
 
 func (c *AuthConfig) GetToken() string {
+	if token := os.Getenv(TokenEnvVar); token != "" {
+		return token
+	}
+
 	authJson := c.ReadConfig()
+	if authJson == nil {
+		return ""
+	}
 	return authJson.Token
 }
 
+// exchanges the refresh token for a new token, and saves it to auth.json
+func (c *AuthConfig) Refresh() error {
+	if c.AuthJson == nil {
+		c.AuthJson = c.ReadConfig()
+	}
+	if c.AuthJson == nil || c.AuthJson.RefreshToken == "" {
+		return ErrRefreshNotSupported
+	}
+
+	body, _ := json.Marshal(map[string]string{"refresh_token": c.AuthJson.RefreshToken})
+
+	// doRequest rather than PostRequest, so a rejected refresh doesn't kick
+	// off another round of re-authentication.
+	httpClient := NewHttpClient(c.AuthJson.Token)
+	jsonData, statusCode, err := httpClient.doRequest(context.Background(), "api/v1/auth/refresh", "POST", body)
+	if statusCode == http.StatusNotFound || statusCode == http.StatusNotImplemented {
+		return ErrRefreshNotSupported
+	}
+	if err != nil {
+		return err
+	}
+
+	refreshed := &refreshTokenResponse{}
+	if err := json.Unmarshal(jsonData, refreshed); err != nil || refreshed.Token == "" {
+		return fmt.Errorf("unexpected refresh response from ultradeck.co: %s", truncateBody(jsonData))
+	}
+
+	c.AuthJson.Token = refreshed.Token
+	c.AuthJson.ExpiresAt = refreshed.ExpiresAt
+	if refreshed.RefreshToken != "" {
+		c.AuthJson.RefreshToken = refreshed.RefreshToken
+	}
+	return c.WriteAuth()
+}
+
+// true if a token is available, either from auth.json or the environment
+func (c *AuthConfig) HasToken() bool {
+	return c.TokenSource() == "env" || c.AuthFileExists()
+}
+
+// "env" if the token comes from ULTRADECK_TOKEN, otherwise "file"
+func (c *AuthConfig) TokenSource() string {
+	if os.Getenv(TokenEnvVar) != "" {
+		return "env"
+	}
+	return "file"
+}
+
+// how long ago the token in auth.json was written.  ok is false when the
+// token comes from the environment, since we can't know when it was issued.
+func (c *AuthConfig) TokenAge() (age time.Duration, ok bool) {
+	if c.TokenSource() == "env" {
+		return 0, false
+	}
+
+	info, err := os.Stat(c.configFileLocation())
+	if err != nil {
+		return 0, false
+	}
+	return time.Since(info.ModTime()), true
+}
+
 func (c *AuthConfig) RemoveAuthFile() {
 	if !c.AuthFileExists() {
 		return
 	}
 
-	if err := os.RemoveAll(c.configFilePath()); err != nil {
+	if err := os.Remove(c.configFileLocation()); err != nil {
 		log.Println("Error removing config file", err)
 	}
+
+	// whatever we knew about the old token no longer applies
+	authCache := &AuthCheckCache{}
+	authCache.Remove()
 }
 
-func (c *AuthConfig) configFilePath() string {
+// returns the profile selected with ULTRADECK_PROFILE
+func Profile() string {
+	if profile := os.Getenv(ProfileEnvVar); profile != "" {
+		return profile
+	}
+	return DefaultProfile
+}
+
+// the home directory ~/.config/ultradeck is in; tests swap it for a
+// temporary one
+var homeDir = func() string {
 	usr, _ := user.Current()
-	return fmt.Sprintf("%s/.config/ultradeck/", usr.HomeDir)
+	return usr.HomeDir
+}
+
+func (c *AuthConfig) configFilePath() string {
+	if profile := Profile(); profile != DefaultProfile {
+		return fmt.Sprintf("%s/.config/ultradeck/profiles/%s/", homeDir(), profile)
+	}
+	return fmt.Sprintf("%s/.config/ultradeck/", homeDir())
+}
+
+// where auth.json lives, for showing to the user
+func (c *AuthConfig) ConfigFileLocation() string {
+	return c.configFileLocation()
 }
 
 func (c *AuthConfig) configFileLocation() string {
@@ -1,11 +1,29 @@
 package client
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// keeps the tests away from the developer's own auth.json and caches
+func TestMain(m *testing.M) {
+	home, err := ioutil.TempDir("", "ultradeck-home")
+	if err != nil {
+		panic(err)
+	}
+	homeDir = func() string { return home }
+
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
 func TestFileExists(t *testing.T) {
 	assert := assert.New(t)
 	authConfig := &AuthConfig{}
@@ -21,20 +39,134 @@ func TestWriteAuth(t *testing.T) {
 	authConfig := &AuthConfig{AuthJson: authJson}
 	authConfig.RemoveAuthFile()
 
-	authConfig.WriteAuth()
+	assert.Nil(authConfig.WriteAuth())
 
 	assert.Equal(true, authConfig.AuthFileExists())
 }
 
+func TestWriteAuthFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv(ProfileEnvVar, "unwritable")
+	defer os.Setenv(ProfileEnvVar, "")
+
+	// a file where the profile's directory should be
+	authConfig := &AuthConfig{AuthJson: &AuthJson{Token: "abcd1234"}}
+	path := strings.TrimSuffix(authConfig.configFilePath(), "/")
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	ioutil.WriteFile(path, []byte("in the way"), 0644)
+	defer os.Remove(path)
+
+	err := authConfig.WriteAuth()
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "could not write")
+}
+
 func TestRemoveAuthFile(t *testing.T) {
 	assert := assert.New(t)
 	authJson := &AuthJson{Token: "abcd1234"}
 	authConfig := &AuthConfig{AuthJson: authJson}
 	authConfig.RemoveAuthFile()
 
-	authConfig.WriteAuth()
+	assert.Nil(authConfig.WriteAuth())
 	assert.Equal(true, authConfig.AuthFileExists())
 
 	authConfig.RemoveAuthFile()
 	assert.Equal(false, authConfig.AuthFileExists())
 }
+
+func TestNewAuthConfig(t *testing.T) {
+	assert := assert.New(t)
+	response := map[string]interface{}{
+		"token":             "abcd1234",
+		"uuid":              "some-uuid",
+		"username":          "gammons",
+		"name":              "Grant",
+		"image_url":         nil,
+		"email":             "grant@example.com",
+		"subscription_name": "free",
+	}
+
+	authConfig, err := NewAuthConfig(response)
+
+	assert.Nil(err)
+	assert.Equal("abcd1234", authConfig.AuthJson.Token)
+	assert.Equal("gammons", authConfig.AuthJson.Username)
+	assert.Equal("", authConfig.AuthJson.ImageUrl)
+}
+
+func TestNewAuthConfigMissingFields(t *testing.T) {
+	assert := assert.New(t)
+	response := map[string]interface{}{"name": "Grant", "token": nil}
+
+	authConfig, err := NewAuthConfig(response)
+
+	assert.Nil(authConfig)
+	assert.EqualError(err, "auth response is missing token, uuid, username")
+}
+
+func TestNewAuthConfigWrongTypes(t *testing.T) {
+	assert := assert.New(t)
+	response := map[string]interface{}{"token": 1234, "uuid": "some-uuid", "username": "gammons"}
+
+	authConfig, err := NewAuthConfig(response)
+
+	assert.Nil(authConfig)
+	assert.NotNil(err)
+}
+
+func TestExpiresSoon(t *testing.T) {
+	assert := assert.New(t)
+
+	authJson := &AuthJson{Token: "abcd1234"}
+	assert.Equal(false, authJson.ExpiresSoon())
+
+	authJson.ExpiresAt = time.Now().Add(time.Hour).Format(time.RFC3339)
+	assert.Equal(false, authJson.ExpiresSoon())
+
+	authJson.ExpiresAt = time.Now().Add(time.Minute).Format(time.RFC3339)
+	assert.Equal(true, authJson.ExpiresSoon())
+
+	authJson.ExpiresAt = time.Now().Add(-time.Hour).Format(time.RFC3339)
+	assert.Equal(true, authJson.ExpiresSoon())
+
+	authJson.ExpiresAt = "not a date"
+	assert.Equal(false, authJson.ExpiresSoon())
+}
+
+func TestRefreshWithoutRefreshToken(t *testing.T) {
+	assert := assert.New(t)
+	authConfig := &AuthConfig{AuthJson: &AuthJson{Token: "abcd1234"}}
+
+	assert.Equal(ErrRefreshNotSupported, authConfig.Refresh())
+}
+
+func TestTokenSource(t *testing.T) {
+	assert := assert.New(t)
+	authConfig := &AuthConfig{}
+
+	os.Setenv(TokenEnvVar, "")
+	assert.Equal("file", authConfig.TokenSource())
+
+	os.Setenv(TokenEnvVar, "fromenv")
+	defer os.Setenv(TokenEnvVar, "")
+
+	assert.Equal("env", authConfig.TokenSource())
+	assert.Equal("fromenv", authConfig.GetToken())
+	assert.Equal(true, authConfig.HasToken())
+}
+
+func TestProfileConfigFilePath(t *testing.T) {
+	assert := assert.New(t)
+	authConfig := &AuthConfig{}
+
+	os.Setenv(ProfileEnvVar, "")
+	assert.Equal(DefaultProfile, Profile())
+	assert.Equal(true, strings.HasSuffix(authConfig.configFilePath(), "/.config/ultradeck/"))
+
+	os.Setenv(ProfileEnvVar, "work")
+	defer os.Setenv(ProfileEnvVar, "")
+
+	assert.Equal("work", Profile())
+	assert.Equal(true, strings.HasSuffix(authConfig.configFilePath(), "/.config/ultradeck/profiles/work/"))
+}
@@ -0,0 +1,18 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+)
+
+// returns how long to wait before retry number attempt (starting at 0): base
+// doubled on every attempt, capped at max, with jitter so clients that
+// failed together don't all retry at the same moment.
+func backoffDelay(base time.Duration, max time.Duration, attempt int) time.Duration {
+	delay := base << uint(attempt)
+	if delay <= 0 || delay > max || attempt > 62 {
+		delay = max
+	}
+	// somewhere between half and all of the delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	for attempt := 0; attempt < 4; attempt++ {
+		full := time.Second << uint(attempt)
+		delay := backoffDelay(time.Second, time.Minute, attempt)
+		assert.True(delay >= full/2 && delay <= full, "attempt %d: %s", attempt, delay)
+	}
+
+	// capped, including when the shift overflows
+	for _, attempt := range []int{10, 70} {
+		delay := backoffDelay(time.Second, 30*time.Second, attempt)
+		assert.True(delay >= 15*time.Second && delay <= 30*time.Second, "attempt %d: %s", attempt, delay)
+	}
+}
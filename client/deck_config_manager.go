@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -43,14 +44,30 @@ type Asset struct {
 
 type DeckConfigManager struct {
 	DeckConfig *DeckConfig
+
+	// Dir is the deck's directory; the working directory if empty
+	Dir string
+
+	// Writes, if set, is told what deck.md will contain before it's written
+	Writes *WriteTracker
 }
 
 func NewDeckConfigManager() *DeckConfigManager {
-	manager := &DeckConfigManager{}
+	return NewDeckConfigManagerIn("")
+}
+
+// NewDeckConfigManagerIn reads the deck config in dir
+func NewDeckConfigManagerIn(dir string) *DeckConfigManager {
+	manager := &DeckConfigManager{Dir: dir}
 	manager.ReadConfig()
 	return manager
 }
 
+// where fileName is in the deck's directory
+func (d *DeckConfigManager) path(fileName string) string {
+	return filepath.Join(d.Dir, fileName)
+}
+
 func (d *DeckConfigManager) NewDeck(title string, description string) *DeckConfig {
 	deck := &DeckConfig{
 		UUID:        NewUUID(),
@@ -83,10 +100,16 @@ func (d *DeckConfigManager) WriteJSON(jsonData []byte) {
 	d.WriteConfig()
 }
 
-// lower-level function to write the DeckConfig to .ud.json
+// lower-level function to write the DeckConfig to .ud.json.  The file is
+// replaced in one go, so it can be read while watch is writing it.
 func (d *DeckConfigManager) WriteConfig() {
 	marshalledData, _ := json.Marshal(d.DeckConfig)
-	if err := ioutil.WriteFile(".ud.json", marshalledData, 0644); err != nil {
+	tmpFile := d.path(".ud.json.tmp")
+	if err := ioutil.WriteFile(tmpFile, marshalledData, 0644); err != nil {
+		log.Println("Error writing deck config: ", err)
+		return
+	}
+	if err := os.Rename(tmpFile, d.path(".ud.json")); err != nil {
 		log.Println("Error writing deck config: ", err)
 	}
 }
@@ -97,7 +120,7 @@ func (d *DeckConfigManager) ReadConfig() {
 		return
 	}
 
-	data, err := ioutil.ReadFile(".ud.json")
+	data, err := ioutil.ReadFile(d.path(".ud.json"))
 	if err != nil {
 		log.Println("error reading deck config file: ", err)
 	}
@@ -113,15 +136,19 @@ func (d *DeckConfigManager) ReadConfig() {
 
 // prepares what's stored in deckConfig to be uploaded to server
 func (d *DeckConfigManager) PrepareJSONForUpload() []byte {
-	d.DeckConfig.Slides = d.ParseDeckMDFile()
-
-	deck := &Deck{Config: d.DeckConfig}
+	deck := &Deck{Config: d.PrepareForUpload()}
 
 	j, _ := json.Marshal(&deck)
 
 	return j
 }
 
+// updates deckConfig with the slides from deck.md, ready to be uploaded
+func (d *DeckConfigManager) PrepareForUpload() *DeckConfig {
+	d.DeckConfig.Slides = d.ParseDeckMDFile()
+	return d.DeckConfig
+}
+
 func (d *DeckConfigManager) GetDeckID() string {
 	return d.DeckConfig.UUID
 }
@@ -132,7 +159,7 @@ func (d *DeckConfigManager) GetDeckShortUUID() string {
 
 // reads the markdown from deck.md file and returns a slide array of slides
 func (d *DeckConfigManager) ParseDeckMDFile() []*Slide {
-	markdown, err := ioutil.ReadFile("deck.md")
+	markdown, err := ioutil.ReadFile(d.path("deck.md"))
 	if err != nil {
 		log.Println("I'm expecting your markdown file to be named deck.md, but I couldn't read it!: ", err)
 	}
@@ -199,18 +226,18 @@ func (d *DeckConfigManager) WriteMarkdownFile(filename string) {
 	}
 
 	// read the current deck.md file and see if it needs updating
-	currentMarkdown, _ := ioutil.ReadFile("deck.md")
+	currentMarkdown, _ := ioutil.ReadFile(d.path(filename))
 	currentMarkdownString := string(currentMarkdown[:])
 	if strings.TrimSpace(currentMarkdownString) == strings.TrimSpace(markdown) {
 		return
 	}
-	if err := ioutil.WriteFile(filename, []byte(markdown), 0644); err != nil {
+	if err := d.Writes.WriteFile(d.path(filename), []byte(markdown)); err != nil {
 		log.Println("Error writing deck.md: ", err)
 	}
 }
 
 func (d *DeckConfigManager) FileExists() bool {
-	if _, err := os.Stat(".ud.json"); os.IsNotExist(err) {
+	if _, err := os.Stat(d.path(".ud.json")); os.IsNotExist(err) {
 		return false
 	}
 	return true
@@ -1,6 +1,9 @@
 package client
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -251,3 +254,27 @@ func TestNewDeck(t *testing.T) {
 	assert.NotEqual(nil, deck.Slides[0].UUID)
 	assert.Equal("# New Slide", deck.Slides[0].Markdown)
 }
+
+func TestDeckConfigManagerInDeckDirectory(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "deck")
+	defer os.RemoveAll(dir)
+
+	manager := &DeckConfigManager{Dir: dir, DeckConfig: &DeckConfig{UUID: "deck-uuid", Slides: []*Slide{{Markdown: "# one"}, {Markdown: "# two"}}}}
+	manager.WriteConfig()
+	manager.WriteMarkdownFile("deck.md")
+
+	markdown, err := ioutil.ReadFile(filepath.Join(dir, "deck.md"))
+	assert.Nil(err)
+	assert.Equal("# one\n\n---\n\n# two", string(markdown))
+	_, err = os.Stat(filepath.Join(dir, ".ud.json.tmp"))
+	assert.True(os.IsNotExist(err), ".ud.json is written in one go")
+
+	manager = NewDeckConfigManagerIn(dir)
+	assert.True(manager.FileExists())
+	assert.Equal("deck-uuid", manager.DeckConfig.UUID)
+	assert.Equal(2, len(manager.ParseDeckMDFile()))
+
+	assert.False(NewDeckConfigManager().FileExists(), "the working directory has no deck")
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"sort"
+)
+
+// what a SlideOp does to a slide
+const (
+	AddSlideOp    = "add"
+	UpdateSlideOp = "update"
+	RemoveSlideOp = "remove"
+	MoveSlideOp   = "move"
+)
+
+// SlideOp is a change to a single slide, identified by its UUID.  Slide is
+// set for adds and updates; Position for adds, updates and moves.
+type SlideOp struct {
+	Op       string `json:"op"`
+	UUID     string `json:"uuid"`
+	Position int    `json:"position,omitempty"`
+	Slide    *Slide `json:"slide,omitempty"`
+}
+
+// DeckPatch is what push sends instead of the whole deck.  BaseUpdatedAt is
+// the updated_at of the deck the ops were computed against, so the server
+// can refuse them if the deck has changed since.
+type DeckPatch struct {
+	BaseUpdatedAt string     `json:"base_updated_at"`
+	Ops           []*SlideOp `json:"ops"`
+}
+
+// DeckChanges is what the server sends back: the ops it applied (with IDs
+// filled in for new slides) and the deck's new updated_at.  Assets is only
+// set if they changed.
+type DeckChanges struct {
+	UpdatedAt string     `json:"updated_at"`
+	Ops       []*SlideOp `json:"ops"`
+	Assets    []*Asset   `json:"assets_attributes,omitempty"`
+}
+
+// DiffSlides returns the ops that turn the synced slides into the current
+// ones.
+func DiffSlides(synced []*Slide, current []*Slide) []*SlideOp {
+	syncedByUUID := map[string]*Slide{}
+	for _, slide := range synced {
+		syncedByUUID[slide.UUID] = slide
+	}
+
+	ops := []*SlideOp{}
+	currentUUIDs := map[string]bool{}
+	for _, slide := range current {
+		currentUUIDs[slide.UUID] = true
+
+		old, ok := syncedByUUID[slide.UUID]
+		switch {
+		case !ok:
+			ops = append(ops, &SlideOp{Op: AddSlideOp, UUID: slide.UUID, Position: slide.Position, Slide: slide})
+		case !sameSlideContent(old, slide):
+			ops = append(ops, &SlideOp{Op: UpdateSlideOp, UUID: slide.UUID, Position: slide.Position, Slide: slide})
+		case old.Position != slide.Position:
+			ops = append(ops, &SlideOp{Op: MoveSlideOp, UUID: slide.UUID, Position: slide.Position})
+		}
+	}
+
+	for _, slide := range synced {
+		if !currentUUIDs[slide.UUID] {
+			ops = append(ops, &SlideOp{Op: RemoveSlideOp, UUID: slide.UUID})
+		}
+	}
+	return ops
+}
+
+func sameSlideContent(a *Slide, b *Slide) bool {
+	return a.Markdown == b.Markdown &&
+		a.PresenterNotes == b.PresenterNotes &&
+		a.ThemeName == b.ThemeName &&
+		a.Layout == b.Layout &&
+		a.ColorVariation == b.ColorVariation
+}
+
+// ApplyChanges applies a change set from the server to the deck, leaving
+// the slides in position order.
+func (d *DeckConfig) ApplyChanges(changes *DeckChanges) {
+	for _, op := range changes.Ops {
+		i := d.slideIndex(op.UUID)
+
+		switch op.Op {
+		case AddSlideOp, UpdateSlideOp:
+			if op.Slide == nil {
+				continue
+			}
+			slide := *op.Slide
+			slide.UUID = op.UUID
+			if op.Position != 0 {
+				slide.Position = op.Position
+			}
+			if i < 0 {
+				d.Slides = append(d.Slides, &slide)
+			} else {
+				d.Slides[i] = &slide
+			}
+		case MoveSlideOp:
+			if i >= 0 {
+				d.Slides[i].Position = op.Position
+			}
+		case RemoveSlideOp:
+			if i >= 0 {
+				d.Slides = append(d.Slides[:i], d.Slides[i+1:]...)
+			}
+		}
+	}
+
+	sort.SliceStable(d.Slides, func(i, j int) bool {
+		return d.Slides[i].Position < d.Slides[j].Position
+	})
+
+	if changes.Assets != nil {
+		d.Assets = changes.Assets
+	}
+	if changes.UpdatedAt != "" {
+		d.UpdatedAt = changes.UpdatedAt
+	}
+}
+
+func (d *DeckConfig) slideIndex(uuid string) int {
+	for i, slide := range d.Slides {
+		if slide.UUID == uuid {
+			return i
+		}
+	}
+	return -1
+}
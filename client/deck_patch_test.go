@@ -0,0 +1,75 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSlides(t *testing.T) {
+	assert := assert.New(t)
+
+	synced := []*Slide{
+		{ID: 1, UUID: "a", Position: 1, Markdown: "# A"},
+		{ID: 2, UUID: "b", Position: 2, Markdown: "# B"},
+		{ID: 3, UUID: "c", Position: 3, Markdown: "# C"},
+		{ID: 4, UUID: "d", Position: 4, Markdown: "# D"},
+	}
+	current := []*Slide{
+		{ID: 1, UUID: "a", Position: 1, Markdown: "# A"},
+		{UUID: "new", Position: 2, Markdown: "# New"},
+		{ID: 3, UUID: "c", Position: 3, Markdown: "# C, edited"},
+		{ID: 2, UUID: "b", Position: 4, Markdown: "# B"},
+	}
+
+	ops := DiffSlides(synced, current)
+
+	assert.Equal(4, len(ops))
+	assert.Equal(&SlideOp{Op: AddSlideOp, UUID: "new", Position: 2, Slide: current[1]}, ops[0])
+	assert.Equal(&SlideOp{Op: UpdateSlideOp, UUID: "c", Position: 3, Slide: current[2]}, ops[1])
+	assert.Equal(&SlideOp{Op: MoveSlideOp, UUID: "b", Position: 4}, ops[2])
+	assert.Equal(&SlideOp{Op: RemoveSlideOp, UUID: "d"}, ops[3])
+}
+
+func TestDiffSlidesUnchanged(t *testing.T) {
+	assert := assert.New(t)
+
+	slides := []*Slide{{UUID: "a", Position: 1, Markdown: "# A"}}
+	assert.Equal(0, len(DiffSlides(slides, slides)))
+}
+
+func TestApplyChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	deck := &DeckConfig{
+		UpdatedAt: "2018-01-01T00:00:00.000Z",
+		Slides: []*Slide{
+			{ID: 1, UUID: "a", Position: 1, Markdown: "# A"},
+			{ID: 2, UUID: "b", Position: 2, Markdown: "# B"},
+			{ID: 3, UUID: "c", Position: 3, Markdown: "# C"},
+		},
+		Assets: []*Asset{{Filename: "cat.png"}},
+	}
+
+	deck.ApplyChanges(&DeckChanges{
+		UpdatedAt: "2018-01-02T00:00:00.000Z",
+		Ops: []*SlideOp{
+			{Op: RemoveSlideOp, UUID: "a"},
+			{Op: UpdateSlideOp, UUID: "b", Position: 2, Slide: &Slide{ID: 2, Markdown: "# B, edited"}},
+			{Op: MoveSlideOp, UUID: "c", Position: 1},
+			{Op: AddSlideOp, UUID: "new", Position: 3, Slide: &Slide{ID: 4, Markdown: "# New"}},
+			{Op: MoveSlideOp, UUID: "missing", Position: 9},
+		},
+	})
+
+	assert.Equal("2018-01-02T00:00:00.000Z", deck.UpdatedAt)
+	assert.Equal(3, len(deck.Slides))
+	assert.Equal("c", deck.Slides[0].UUID)
+	assert.Equal("b", deck.Slides[1].UUID)
+	assert.Equal("# B, edited", deck.Slides[1].Markdown)
+	assert.Equal("new", deck.Slides[2].UUID)
+	assert.Equal(4, deck.Slides[2].ID)
+
+	// assets are left alone unless the server sent them
+	assert.Equal("cat.png", deck.Assets[0].Filename)
+}
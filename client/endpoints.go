@@ -0,0 +1,293 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	APIURLEnvVar        = "ULTRADECK_API_URL"
+	FrontendURLEnvVar   = "ULTRADECK_APP_URL"
+	WebsocketURLEnvVar  = "ULTRADECK_WS_URL"
+	CABundleEnvVar      = "ULTRADECK_CA_BUNDLE"
+	ProxyEnvVar         = "ULTRADECK_PROXY"
+	AllowInsecureEnvVar = "ULTRADECK_ALLOW_INSECURE"
+)
+
+// Endpoints are the addresses of the ultradeck.co services the CLI talks to,
+// and how to connect to them.
+type Endpoints struct {
+	APIURL       string `json:"api_url"`
+	FrontendURL  string `json:"frontend_url"`
+	WebsocketURL string `json:"websocket_url"`
+
+	// PEM file of extra certificate authorities to trust, e.g. for a
+	// self-hosted instance with its own CA
+	CABundle string `json:"ca_bundle"`
+
+	// proxy for API and websocket connections.  If empty, the usual
+	// HTTPS_PROXY and NO_PROXY environment variables apply.
+	Proxy string `json:"proxy"`
+
+	// AllowInsecure allows http:// and ws:// API and websocket URLs, which
+	// send the token and websocket channel in cleartext
+	AllowInsecure bool `json:"allow_insecure"`
+
+	tlsConfig *tls.Config
+	proxyURL  *url.URL
+	transport *http.Transport
+}
+
+var (
+	ProductionEndpoints = Endpoints{
+		APIURL:       "https://api.ultradeck.co",
+		FrontendURL:  "https://app.ultradeck.co",
+		WebsocketURL: "wss://ws.ultradeck.co/",
+	}
+
+	// used when DEV_MODE is set
+	DevEndpoints = Endpoints{
+		APIURL:        "http://localhost:3001",
+		FrontendURL:   "http://localhost:3000",
+		WebsocketURL:  "ws://localhost:8080/",
+		AllowInsecure: true,
+	}
+)
+
+var (
+	currentEndpoints *Endpoints
+	endpointsMutex   sync.Mutex
+)
+
+// LoadEndpoints works out which endpoints to use.  Each one comes from the
+// first place that sets it: overrides (i.e. command line flags), the
+// environment, config.json in the profile's config directory, and finally
+// the production or DEV_MODE defaults.
+func LoadEndpoints(overrides Endpoints) (*Endpoints, error) {
+	endpoints := ProductionEndpoints
+	if os.Getenv("DEV_MODE") != "" {
+		endpoints = DevEndpoints
+	}
+
+	fromFile, err := readEndpointsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	fromEnv := Endpoints{
+		APIURL:        os.Getenv(APIURLEnvVar),
+		FrontendURL:   os.Getenv(FrontendURLEnvVar),
+		WebsocketURL:  os.Getenv(WebsocketURLEnvVar),
+		CABundle:      os.Getenv(CABundleEnvVar),
+		Proxy:         os.Getenv(ProxyEnvVar),
+		AllowInsecure: allowInsecureFromEnv(),
+	}
+
+	for _, e := range []Endpoints{fromFile, fromEnv, overrides} {
+		endpoints.merge(e)
+	}
+
+	if err := endpoints.validate(); err != nil {
+		return nil, err
+	}
+	return &endpoints, nil
+}
+
+// ULTRADECK_ALLOW_INSECURE takes whatever strconv.ParseBool does, so 0 and
+// false mean no.  Anything else is ignored, rather than guessed at.
+func allowInsecureFromEnv() bool {
+	value := os.Getenv(AllowInsecureEnvVar)
+	if value == "" {
+		return false
+	}
+	allow, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Ignoring %s=%q; set it to 1 or true to allow connecting without TLS", AllowInsecureEnvVar, value)
+		return false
+	}
+	return allow
+}
+
+// SetEndpoints makes every client in this process use the given endpoints
+func SetEndpoints(endpoints *Endpoints) {
+	endpointsMutex.Lock()
+	defer endpointsMutex.Unlock()
+	currentEndpoints = endpoints
+}
+
+// CurrentEndpoints returns the endpoints set with SetEndpoints, loading them
+// from the environment and config file if they haven't been set yet.
+func CurrentEndpoints() *Endpoints {
+	endpointsMutex.Lock()
+	defer endpointsMutex.Unlock()
+
+	if currentEndpoints == nil {
+		endpoints, err := LoadEndpoints(Endpoints{})
+		if err != nil {
+			DebugMsg(fmt.Sprintf("Falling back to default endpoints: %s", err))
+			endpoints = &ProductionEndpoints
+		}
+		currentEndpoints = endpoints
+	}
+	return currentEndpoints
+}
+
+// overwrites the endpoints other sets, leaving the rest alone
+func (e *Endpoints) merge(other Endpoints) {
+	if other.APIURL != "" {
+		e.APIURL = other.APIURL
+	}
+	if other.FrontendURL != "" {
+		e.FrontendURL = other.FrontendURL
+	}
+	if other.WebsocketURL != "" {
+		e.WebsocketURL = other.WebsocketURL
+	}
+	if other.CABundle != "" {
+		e.CABundle = other.CABundle
+	}
+	if other.Proxy != "" {
+		e.Proxy = other.Proxy
+	}
+	if other.AllowInsecure {
+		e.AllowInsecure = true
+	}
+}
+
+// TLSConfig trusts the system's certificate authorities plus any in
+// CABundle.  It is nil when there's no CA bundle, to use Go's defaults.
+func (e *Endpoints) TLSConfig() *tls.Config {
+	return e.tlsConfig
+}
+
+// ProxyFunc returns the proxy to use for a request, for http.Transport and
+// websocket.Dialer
+func (e *Endpoints) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	if e.proxyURL != nil {
+		return http.ProxyURL(e.proxyURL)
+	}
+	return http.ProxyFromEnvironment
+}
+
+// Transport returns an http.Transport that connects using these settings.
+// It's shared, so connections to the API are reused between requests.
+func (e *Endpoints) Transport() *http.Transport {
+	if e.transport != nil {
+		return e.transport
+	}
+	return e.newTransport()
+}
+
+func (e *Endpoints) newTransport() *http.Transport {
+	return &http.Transport{
+		Proxy:               e.ProxyFunc(),
+		TLSClientConfig:     e.TLSConfig(),
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
+
+func (e *Endpoints) validate() error {
+	if err := validateEndpoint("API", e.APIURL, "http", "https"); err != nil {
+		return err
+	}
+	if err := validateEndpoint("app", e.FrontendURL, "http", "https"); err != nil {
+		return err
+	}
+	if err := validateEndpoint("websocket", e.WebsocketURL, "ws", "wss"); err != nil {
+		return err
+	}
+
+	if !e.AllowInsecure {
+		for _, endpoint := range []string{e.APIURL, e.WebsocketURL} {
+			if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "ws://") {
+				return fmt.Errorf("refusing to connect to %s without TLS.  Use --allow-insecure or set %s=1 if you really mean to", endpoint, AllowInsecureEnvVar)
+			}
+		}
+	}
+
+	if e.Proxy != "" {
+		proxyURL, err := url.Parse(e.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("the proxy URL %q is not a valid URL", e.Proxy)
+		}
+		e.proxyURL = proxyURL
+	}
+
+	if e.CABundle != "" {
+		tlsConfig, err := loadCABundle(e.CABundle)
+		if err != nil {
+			return err
+		}
+		e.tlsConfig = tlsConfig
+	}
+
+	e.transport = e.newTransport()
+
+	e.APIURL = strings.TrimSuffix(e.APIURL, "/")
+	e.FrontendURL = strings.TrimSuffix(e.FrontendURL, "/")
+	return nil
+}
+
+func validateEndpoint(name string, endpoint string, schemes ...string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("the %s URL %q is not a valid URL", name, endpoint)
+	}
+
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf("the %s URL %q must start with %s://", name, endpoint, strings.Join(schemes, ":// or "))
+}
+
+func loadCABundle(path string) (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA bundle: %s", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+func readEndpointsFile() (Endpoints, error) {
+	var endpoints Endpoints
+
+	data, err := ioutil.ReadFile(endpointsFileLocation())
+	if os.IsNotExist(err) {
+		return endpoints, nil
+	}
+	if err != nil {
+		return endpoints, err
+	}
+
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return endpoints, fmt.Errorf("could not read %s: %s", endpointsFileLocation(), err)
+	}
+	return endpoints, nil
+}
+
+func endpointsFileLocation() string {
+	authConfig := &AuthConfig{}
+	return authConfig.configFilePath() + "config.json"
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadEndpointsDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	endpoints, err := LoadEndpoints(Endpoints{})
+
+	assert.Nil(err)
+	assert.Equal(ProductionEndpoints.APIURL, endpoints.APIURL)
+	assert.Equal("wss://ws.ultradeck.co/", endpoints.WebsocketURL)
+	assert.Equal(false, endpoints.AllowInsecure)
+
+	os.Setenv("DEV_MODE", "1")
+	defer os.Unsetenv("DEV_MODE")
+
+	endpoints, err = LoadEndpoints(Endpoints{})
+
+	assert.Nil(err)
+	assert.Equal(DevEndpoints.APIURL, endpoints.APIURL)
+	assert.Equal(DevEndpoints.WebsocketURL, endpoints.WebsocketURL)
+}
+
+func TestLoadEndpointsPrecedence(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv(ProfileEnvVar, "endpoints-test")
+	defer os.Unsetenv(ProfileEnvVar)
+
+	authConfig := &AuthConfig{}
+	os.MkdirAll(authConfig.configFilePath(), os.ModePerm)
+	defer os.RemoveAll(authConfig.configFilePath())
+
+	config := []byte(`{"api_url":"https://api.staging.example.com/","frontend_url":"https://app.staging.example.com"}`)
+	ioutil.WriteFile(endpointsFileLocation(), config, 0644)
+
+	os.Setenv(FrontendURLEnvVar, "http://localhost:4000")
+	defer os.Unsetenv(FrontendURLEnvVar)
+
+	endpoints, err := LoadEndpoints(Endpoints{WebsocketURL: "wss://ws.staging.example.com/"})
+
+	assert.Nil(err)
+	assert.Equal("https://api.staging.example.com", endpoints.APIURL)
+	assert.Equal("http://localhost:4000", endpoints.FrontendURL)
+	assert.Equal("wss://ws.staging.example.com/", endpoints.WebsocketURL)
+}
+
+func TestLoadEndpointsInsecure(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := LoadEndpoints(Endpoints{WebsocketURL: "ws://ws.example.com/"})
+	assert.EqualError(err, "refusing to connect to ws://ws.example.com/ without TLS.  Use --allow-insecure or set ULTRADECK_ALLOW_INSECURE=1 if you really mean to")
+
+	_, err = LoadEndpoints(Endpoints{APIURL: "http://api.example.com"})
+	assert.NotNil(err)
+
+	endpoints, err := LoadEndpoints(Endpoints{WebsocketURL: "ws://ws.example.com/", AllowInsecure: true})
+	assert.Nil(err)
+	assert.Equal("ws://ws.example.com/", endpoints.WebsocketURL)
+}
+
+func TestLoadEndpointsAllowInsecureEnvVar(t *testing.T) {
+	assert := assert.New(t)
+	defer os.Unsetenv(AllowInsecureEnvVar)
+
+	for value, allowed := range map[string]bool{"1": true, "true": true, "TRUE": true, "0": false, "false": false, "yes": false} {
+		os.Setenv(AllowInsecureEnvVar, value)
+		_, err := LoadEndpoints(Endpoints{WebsocketURL: "ws://ws.example.com/"})
+		assert.Equal(allowed, err == nil, "%s=%s", AllowInsecureEnvVar, value)
+	}
+}
+
+func TestLoadEndpointsCABundle(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	bundle, _ := ioutil.TempFile("", "ca-bundle")
+	defer os.Remove(bundle.Name())
+	pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	bundle.Close()
+
+	SetEndpoints(nil)
+	defer SetEndpoints(nil)
+
+	httpClient := &HttpClient{BaseURL: server.URL, MaxRetries: -1}
+	_, _, err := httpClient.GetRequest("api/v1/auth/me")
+	assert.IsType(&NetworkError{}, err, "the test server's certificate shouldn't be trusted yet")
+
+	endpoints, err := LoadEndpoints(Endpoints{CABundle: bundle.Name()})
+	assert.Nil(err)
+	SetEndpoints(endpoints)
+
+	_, statusCode, err := httpClient.GetRequest("api/v1/auth/me")
+	assert.Nil(err)
+	assert.Equal(200, statusCode)
+}
+
+func TestLoadEndpointsInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := LoadEndpoints(Endpoints{CABundle: "/does/not/exist.pem"})
+	assert.NotNil(err)
+
+	_, err = LoadEndpoints(Endpoints{APIURL: "localhost:3001"})
+	assert.NotNil(err)
+
+	_, err = LoadEndpoints(Endpoints{WebsocketURL: "https://ws.example.com"})
+	assert.EqualError(err, `the websocket URL "https://ws.example.com" must start with ws:// or wss://`)
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// NetworkError is returned when ultradeck.co couldn't be reached at all,
+// or the connection broke before the whole response came back.
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("could not reach ultradeck.co: %s", e.Err)
+}
+
+// ClientError is returned when the backend rejects a request with a 4xx.
+// Message is the error the server gave, or the start of the response body.
+type ClientError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ClientError) Error() string {
+	return fmt.Sprintf("ultradeck.co rejected the request (%d): %s", e.StatusCode, e.Message)
+}
+
+// ServerError is returned when the backend fails with a 5xx.
+type ServerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("ultradeck.co had a problem (%d): %s", e.StatusCode, e.Message)
+}
+
+// true if err means ultradeck.co couldn't be reached, e.g. because we're
+// offline
+func IsNetworkError(err error) bool {
+	_, ok := err.(*NetworkError)
+	return ok
+}
+
+// true if err is a ClientError for a missing resource
+func IsNotFound(err error) bool {
+	return hasClientStatus(err, http.StatusNotFound)
+}
+
+// true if the server refused a change because the deck changed on
+// ultradeck.co since
+func IsConflict(err error) bool {
+	return hasClientStatus(err, http.StatusConflict)
+}
+
+// true if err is a ClientError for a missing or rejected token
+func IsUnauthorized(err error) bool {
+	return hasClientStatus(err, http.StatusUnauthorized)
+}
+
+// true if err means the server doesn't have the endpoint at all, i.e. it's
+// older than this client
+func IsNotSupported(err error) bool {
+	if serverErr, ok := err.(*ServerError); ok {
+		return serverErr.StatusCode == http.StatusNotImplemented
+	}
+	return IsNotFound(err) || hasClientStatus(err, http.StatusMethodNotAllowed)
+}
+
+func hasClientStatus(err error, statusCode int) bool {
+	clientErr, ok := err.(*ClientError)
+	return ok && clientErr.StatusCode == statusCode
+}
+
+// builds the error for a response, or nil if the status was a success
+func errorForStatus(statusCode int, bodyBytes []byte) error {
+	switch {
+	case statusCode >= 500:
+		return &ServerError{StatusCode: statusCode, Message: serverErrorMessage(bodyBytes)}
+	case statusCode >= 400:
+		return &ClientError{StatusCode: statusCode, Message: serverErrorMessage(bodyBytes)}
+	}
+	return nil
+}
+
+type serverErrorBody struct {
+	Error   string   `json:"error"`
+	Errors  []string `json:"errors"`
+	Message string   `json:"message"`
+}
+
+// pulls the error message out of a failed response, falling back to the
+// body itself when it isn't the JSON we expect
+func serverErrorMessage(bodyBytes []byte) string {
+	var serverErr serverErrorBody
+	if err := json.Unmarshal(bodyBytes, &serverErr); err == nil {
+		switch {
+		case serverErr.Error != "":
+			return serverErr.Error
+		case len(serverErr.Errors) > 0:
+			return strings.Join(serverErr.Errors, ", ")
+		case serverErr.Message != "":
+			return serverErr.Message
+		}
+	}
+	return truncateBody(bodyBytes)
+}
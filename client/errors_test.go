@@ -0,0 +1,62 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerErrorMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("Deck not found", serverErrorMessage([]byte(`{"error":"Deck not found"}`)))
+	assert.Equal("Title is too short, Slides are invalid", serverErrorMessage([]byte(`{"errors":["Title is too short","Slides are invalid"]}`)))
+	assert.Equal("Rate limited", serverErrorMessage([]byte(`{"message":"Rate limited"}`)))
+	assert.Equal("<html>502 Bad Gateway</html>", serverErrorMessage([]byte("<html>502 Bad Gateway</html>")))
+}
+
+func TestErrorForStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(errorForStatus(200, []byte("{}")))
+	assert.Nil(errorForStatus(204, nil))
+
+	err := errorForStatus(404, []byte(`{"error":"Deck not found"}`))
+	assert.EqualError(err, "ultradeck.co rejected the request (404): Deck not found")
+	assert.Equal(true, IsNotFound(err))
+	assert.Equal(false, IsUnauthorized(err))
+
+	err = errorForStatus(502, []byte("<html>502 Bad Gateway</html>"))
+	assert.EqualError(err, "ultradeck.co had a problem (502): <html>502 Bad Gateway</html>")
+	assert.Equal(false, IsNotFound(err))
+}
+
+func TestNetworkError(t *testing.T) {
+	assert := assert.New(t)
+	err := &NetworkError{Err: errors.New("connection refused")}
+
+	assert.EqualError(err, "could not reach ultradeck.co: connection refused")
+	assert.Equal(false, IsNotFound(err))
+	assert.True(IsNetworkError(err))
+	assert.False(IsNetworkError(&ServerError{StatusCode: 500}))
+}
+
+func TestIsNotSupported(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(IsNotSupported(&ClientError{StatusCode: 404}))
+	assert.True(IsNotSupported(&ClientError{StatusCode: 405}))
+	assert.True(IsNotSupported(&ServerError{StatusCode: 501}))
+	assert.False(IsNotSupported(&ClientError{StatusCode: 409}))
+	assert.False(IsNotSupported(&ServerError{StatusCode: 500}))
+	assert.False(IsNotSupported(&NetworkError{}))
+}
+
+func TestIsConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(IsConflict(&ClientError{StatusCode: 409}))
+	assert.False(IsConflict(&ClientError{StatusCode: 404}))
+	assert.False(IsConflict(&ServerError{StatusCode: 500}))
+}
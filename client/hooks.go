@@ -0,0 +1,207 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// the sync events hooks can run on
+const (
+	BeforePushEvent = "before-push"
+	AfterPushEvent  = "after-push"
+	AfterPullEvent  = "after-pull"
+	ConflictEvent   = "conflict"
+	ErrorEvent      = "error"
+)
+
+// how to get the user's attention on after-pull, conflict and error
+const (
+	NotifyBell = "bell"
+	NotifyOSC  = "osc"
+)
+
+const NotifyEnvVar = "ULTRADECK_NOTIFY"
+
+// how long a hook may run before it's killed
+const hookTimeout = time.Minute
+
+// HookEvent is what a hook gets on stdin, as JSON
+type HookEvent struct {
+	Event     string   `json:"event"`
+	Command   string   `json:"command"`
+	DeckUUID  string   `json:"deck_uuid"`
+	DeckTitle string   `json:"deck_title"`
+	Dir       string   `json:"dir"`
+	Files     []string `json:"files,omitempty"`
+	Slides    []string `json:"slides,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Time      string   `json:"time"`
+
+	// where the hook's output goes; nil for our stdout and stderr
+	Output io.Writer `json:"-"`
+}
+
+// Hooks are the commands to run on sync events, and how to notify the user
+// about them, from config.json:
+//
+//	{"hooks": {"after-pull": "emacsclient -e '(revert-buffer t t)'"}, "notify": "bell"}
+type Hooks struct {
+	Commands map[string]string `json:"hooks"`
+	Notify   string            `json:"notify"`
+
+	// where notifications go; nil for stderr, if it's a terminal
+	notifyOut io.Writer
+}
+
+// LoadHooks reads the hooks from config.json.  ULTRADECK_NOTIFY overrides
+// the notify setting.
+func LoadHooks() (*Hooks, error) {
+	hooks := &Hooks{}
+
+	data, err := ioutil.ReadFile(endpointsFileLocation())
+	if err != nil && !os.IsNotExist(err) {
+		return hooks, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, hooks); err != nil {
+			return hooks, fmt.Errorf("could not read %s: %s", endpointsFileLocation(), err)
+		}
+	}
+
+	if notify := os.Getenv(NotifyEnvVar); notify != "" {
+		hooks.Notify = notify
+	}
+	for event := range hooks.Commands {
+		if !validHookEvent(event) {
+			return hooks, fmt.Errorf("unknown hook %q in %s", event, endpointsFileLocation())
+		}
+	}
+	switch hooks.Notify {
+	case "", NotifyBell, NotifyOSC:
+	default:
+		return hooks, fmt.Errorf("notify must be %q or %q, not %q", NotifyBell, NotifyOSC, hooks.Notify)
+	}
+	return hooks, nil
+}
+
+func validHookEvent(event string) bool {
+	switch event {
+	case BeforePushEvent, AfterPushEvent, AfterPullEvent, ConflictEvent, ErrorEvent:
+		return true
+	}
+	return false
+}
+
+// Run notifies the user about event if it's one worth knowing about, then
+// runs its hook, if there is one, in event.Dir with the event on stdin.  The
+// hook's output goes to event.Output.  An error means the hook failed; for
+// before-push, that stops the push.  A nil *Hooks does nothing.
+func (h *Hooks) Run(event *HookEvent) error {
+	if h == nil {
+		return nil
+	}
+	if event.Time == "" {
+		event.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	if event.Dir == "" {
+		event.Dir, _ = os.Getwd()
+	}
+
+	h.notify(event)
+
+	command := h.Commands[event.Event]
+	if command == "" {
+		return nil
+	}
+	DebugMsg(fmt.Sprintf("running %s hook: %s", event.Event, command))
+
+	input, _ := json.Marshal(event)
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, command)
+	cmd.Dir = event.Dir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if event.Output != nil {
+		cmd.Stdout = event.Output
+		cmd.Stderr = event.Output
+	}
+	cmd.Env = append(os.Environ(), "ULTRADECK_EVENT="+event.Event)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("the %s hook took longer than %s", event.Event, hookTimeout)
+		}
+		return fmt.Errorf("the %s hook failed: %s", event.Event, err)
+	}
+	return nil
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+func (h *Hooks) notify(event *HookEvent) {
+	message := notificationMessage(event)
+	if h.Notify == "" || message == "" {
+		return
+	}
+
+	out := h.notifyOut
+	if out == nil {
+		if !isatty.IsTerminal(os.Stderr.Fd()) {
+			return
+		}
+		out = os.Stderr
+	}
+
+	switch h.Notify {
+	case NotifyBell:
+		fmt.Fprint(out, "\a")
+	case NotifyOSC:
+		// OSC 9 shows a desktop notification in iTerm2, Windows Terminal
+		// and others; terminals that don't know it ignore it.  Control
+		// characters would end the sequence early.
+		message = strings.Map(func(r rune) rune {
+			if r < ' ' || r == 0x7f {
+				return -1
+			}
+			return r
+		}, message)
+		fmt.Fprintf(out, "\x1b]9;%s\a", message)
+	}
+}
+
+// what the user is told about event, or "" for events not worth
+// interrupting them for
+func notificationMessage(event *HookEvent) string {
+	deck := event.DeckTitle
+	if deck == "" {
+		deck = "ultradeck"
+	}
+
+	switch event.Event {
+	case AfterPullEvent:
+		return fmt.Sprintf("%s: pulled changes from ultradeck.co", deck)
+	case ConflictEvent:
+		return fmt.Sprintf("%s: your changes conflict with changes on ultradeck.co", deck)
+	case ErrorEvent:
+		return fmt.Sprintf("%s: %s", deck, strings.SplitN(event.Error, "\n", 2)[0])
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv(ProfileEnvVar, "hooks-test")
+	defer os.Unsetenv(ProfileEnvVar)
+
+	hooks, err := LoadHooks()
+	assert.Nil(err)
+	assert.Empty(hooks.Commands, "no config.json means no hooks")
+
+	authConfig := &AuthConfig{}
+	os.MkdirAll(authConfig.configFilePath(), os.ModePerm)
+	defer os.RemoveAll(authConfig.configFilePath())
+
+	config := []byte(`{"api_url":"https://api.ultradeck.co","hooks":{"after-pull":"touch pulled"},"notify":"bell"}`)
+	ioutil.WriteFile(endpointsFileLocation(), config, 0644)
+
+	hooks, err = LoadHooks()
+	assert.Nil(err)
+	assert.Equal("touch pulled", hooks.Commands[AfterPullEvent])
+	assert.Equal(NotifyBell, hooks.Notify)
+
+	os.Setenv(NotifyEnvVar, NotifyOSC)
+	defer os.Unsetenv(NotifyEnvVar)
+	hooks, _ = LoadHooks()
+	assert.Equal(NotifyOSC, hooks.Notify, "the environment wins")
+
+	ioutil.WriteFile(endpointsFileLocation(), []byte(`{"hooks":{"after-pul":"touch pulled"}}`), 0644)
+	_, err = LoadHooks()
+	assert.Contains(err.Error(), `unknown hook "after-pul"`)
+}
+
+func TestRunHookGetsEventOnStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "hooks")
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "event.json")
+
+	hooks := &Hooks{Commands: map[string]string{
+		AfterPullEvent:  "cat > " + output,
+		BeforePushEvent: "test \"$ULTRADECK_EVENT\" = before-push && exit 3",
+	}}
+
+	err := hooks.Run(&HookEvent{Event: AfterPullEvent, Command: "watch", DeckUUID: "deck-1", Files: []string{"deck.md"}})
+	assert.Nil(err)
+
+	event := &HookEvent{}
+	data, _ := ioutil.ReadFile(output)
+	assert.Nil(json.Unmarshal(data, event))
+	assert.Equal(AfterPullEvent, event.Event)
+	assert.Equal("watch", event.Command)
+	assert.Equal("deck-1", event.DeckUUID)
+	assert.Equal([]string{"deck.md"}, event.Files)
+	assert.NotEmpty(event.Time)
+	assert.NotEmpty(event.Dir)
+
+	err = hooks.Run(&HookEvent{Event: BeforePushEvent})
+	assert.Contains(err.Error(), "the before-push hook failed")
+
+	hooks.Commands[AfterPushEvent] = "touch pushed"
+	assert.Nil(hooks.Run(&HookEvent{Event: AfterPushEvent, Dir: dir}))
+	_, err = os.Stat(filepath.Join(dir, "pushed"))
+	assert.Nil(err, "the hook runs in the deck directory")
+	delete(hooks.Commands, AfterPushEvent)
+
+	var hookOutput bytes.Buffer
+	hooks.Commands[ErrorEvent] = "echo rebuilt; echo oops >&2"
+	assert.Nil(hooks.Run(&HookEvent{Event: ErrorEvent, Output: &hookOutput}))
+	assert.Equal("rebuilt\noops\n", hookOutput.String())
+	delete(hooks.Commands, ErrorEvent)
+
+	assert.Nil(hooks.Run(&HookEvent{Event: AfterPushEvent}), "no hook, nothing to fail")
+	assert.Nil((*Hooks)(nil).Run(&HookEvent{Event: AfterPushEvent}))
+}
+
+func TestHookNotifications(t *testing.T) {
+	assert := assert.New(t)
+
+	out := &bytes.Buffer{}
+	hooks := &Hooks{Notify: NotifyBell, notifyOut: out}
+	hooks.Run(&HookEvent{Event: AfterPushEvent})
+	assert.Equal("", out.String(), "pushes aren't worth a notification")
+	hooks.Run(&HookEvent{Event: AfterPullEvent})
+	assert.Equal("\a", out.String())
+
+	out.Reset()
+	hooks.Notify = NotifyOSC
+	hooks.Run(&HookEvent{Event: ErrorEvent, DeckTitle: "My \x1bdeck", Error: "could not reach ultradeck.co\nsecond line"})
+	assert.Equal("\x1b]9;My deck: could not reach ultradeck.co\a", out.String())
+}
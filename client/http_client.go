@@ -2,42 +2,123 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	BackendURL    = "https://api.ultradeck.co/"
-	DevBackendURL = "http://localhost:3001/"
+	// how long a single attempt may take, unless ULTRADECK_HTTP_TIMEOUT says otherwise
+	DefaultTimeout = 30 * time.Second
+	TimeoutEnvVar  = "ULTRADECK_HTTP_TIMEOUT"
+
+	// how many times a failed request is retried
+	DefaultMaxRetries = 3
+
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	maxRetryDelay         = 30 * time.Second
 )
 
+// UnauthorizedHandler is called when the backend rejects a token with a 401.
+// It returns a new token to retry the request with, or false to give up.
+var UnauthorizedHandler func(token string) (string, bool)
+
 type HttpClient struct {
-	Token    string
-	Response *http.Response
+	Token string
+
+	// BaseURL defaults to the API URL from CurrentEndpoints
+	BaseURL string
+
+	// Timeout applies to each attempt.  Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// MaxRetries is how many times a request is retried after a network
+	// error, 429 or 5xx.  Requests that aren't idempotent are only retried
+	// after a 429, since the server didn't act on them.  A negative value
+	// disables retries; 0 means DefaultMaxRetries.
+	MaxRetries int
+
+	// RetryBaseDelay is the first backoff delay, doubled on every retry
+	RetryBaseDelay time.Duration
 }
 
 func NewHttpClient(token string) *HttpClient {
 	return &HttpClient{Token: token}
 }
 
-func (h *HttpClient) GetRequest(path string) []byte {
+func (h *HttpClient) GetRequest(path string) ([]byte, int, error) {
 	return h.PerformRequest(path, "GET", []byte(""))
 }
 
-func (h *HttpClient) PostRequest(path string, body []byte) []byte {
+func (h *HttpClient) PostRequest(path string, body []byte) ([]byte, int, error) {
 	return h.PerformRequest(path, "POST", body)
 }
 
-func (h *HttpClient) PutRequest(path string, body []byte) []byte {
+func (h *HttpClient) PutRequest(path string, body []byte) ([]byte, int, error) {
 	return h.PerformRequest(path, "PUT", body)
 }
 
-func (h *HttpClient) PerformRequest(path string, verb string, body []byte) []byte {
-	url := h.backendURL() + path
-	client := &http.Client{}
-	req, _ := http.NewRequest(verb, url, bytes.NewBuffer(body))
+func (h *HttpClient) DeleteRequest(path string) ([]byte, int, error) {
+	return h.PerformRequest(path, "DELETE", []byte(""))
+}
+
+func (h *HttpClient) PerformRequest(path string, verb string, body []byte) ([]byte, int, error) {
+	return h.PerformRequestContext(context.Background(), path, verb, body)
+}
+
+// performs the request and returns the response body and status.  The error
+// is a *NetworkError if the backend couldn't be reached, or a *ClientError
+// or *ServerError for 4xx and 5xx responses, in which case the body is
+// still returned.  Cancelling ctx aborts the request and any retries.
+func (h *HttpClient) PerformRequestContext(ctx context.Context, path string, verb string, body []byte) ([]byte, int, error) {
+	bodyBytes, statusCode, err := h.doRequest(ctx, path, verb, body)
+
+	if statusCode == http.StatusUnauthorized && UnauthorizedHandler != nil {
+		DebugMsg("Got a 401, re-authenticating")
+		if token, ok := UnauthorizedHandler(h.Token); ok {
+			h.Token = token
+			bodyBytes, statusCode, err = h.doRequest(ctx, path, verb, body)
+		}
+	}
+
+	return bodyBytes, statusCode, err
+}
+
+// performs the request, retrying with backoff when it's safe to
+func (h *HttpClient) doRequest(ctx context.Context, path string, verb string, body []byte) ([]byte, int, error) {
+	for attempt := 0; ; attempt++ {
+		bodyBytes, statusCode, retryAfter, err := h.doAttempt(ctx, path, verb, body)
+
+		if attempt >= h.maxRetries() || !h.shouldRetry(verb, statusCode, err) || ctx.Err() != nil {
+			return bodyBytes, statusCode, err
+		}
+
+		delay := h.retryDelay(attempt, retryAfter)
+		DebugMsg(fmt.Sprintf("Retrying %s %s in %s: %s", verb, path, delay, err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return bodyBytes, statusCode, &NetworkError{Err: ctx.Err()}
+		case <-timer.C:
+		}
+	}
+}
+
+func (h *HttpClient) doAttempt(ctx context.Context, path string, verb string, body []byte) ([]byte, int, time.Duration, error) {
+	url := strings.TrimSuffix(h.baseURL(), "/") + "/" + strings.TrimPrefix(path, "/")
+	client := &http.Client{Timeout: h.timeout(), Transport: CurrentEndpoints().Transport()}
+	req, err := http.NewRequest(verb, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	req = req.WithContext(ctx)
 	DebugMsg("Verb is " + verb)
 	DebugMsg("url is " + url)
 	DebugMsg("body is " + string(body[:]))
@@ -47,25 +128,100 @@ func (h *HttpClient) PerformRequest(path string, verb string, body []byte) []byt
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	var requestError error
-	if h.Response, requestError = client.Do(req); requestError != nil {
-		fmt.Println("Error contacting server: ", requestError)
-		os.Exit(0)
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, 0, 0, &NetworkError{Err: err}
 	}
+	defer response.Body.Close()
+
+	retryAfter := parseRetryAfter(response.Header.Get("Retry-After"))
 
-	bodyBytes, err := ioutil.ReadAll(h.Response.Body)
+	bodyBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		panic(err)
+		return nil, response.StatusCode, retryAfter, &NetworkError{Err: err}
 	}
-	defer h.Response.Body.Close()
 
-	return bodyBytes
+	return bodyBytes, response.StatusCode, retryAfter, errorForStatus(response.StatusCode, bodyBytes)
+}
+
+func (h *HttpClient) shouldRetry(verb string, statusCode int, err error) bool {
+	if statusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if !isIdempotent(verb) {
+		return false
+	}
+
+	switch err.(type) {
+	case *NetworkError, *ServerError:
+		return true
+	}
+	return false
+}
+
+// exponential backoff with jitter, unless the server asked for a delay
+func (h *HttpClient) retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if retryAfter > maxRetryDelay {
+			return maxRetryDelay
+		}
+		return retryAfter
+	}
+	return backoffDelay(h.retryBaseDelay(), maxRetryDelay, attempt)
+}
+
+func isIdempotent(verb string) bool {
+	switch verb {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+func (h *HttpClient) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	if timeout, err := time.ParseDuration(os.Getenv(TimeoutEnvVar)); err == nil && timeout > 0 {
+		return timeout
+	}
+	return DefaultTimeout
+}
+
+func (h *HttpClient) maxRetries() int {
+	switch {
+	case h.MaxRetries < 0:
+		return 0
+	case h.MaxRetries == 0:
+		return DefaultMaxRetries
+	}
+	return h.MaxRetries
+}
+
+func (h *HttpClient) retryBaseDelay() time.Duration {
+	if h.RetryBaseDelay > 0 {
+		return h.RetryBaseDelay
+	}
+	return defaultRetryBaseDelay
 }
 
-func (h *HttpClient) backendURL() string {
-	if os.Getenv("DEV_MODE") != "" {
-		return DevBackendURL
-	} else {
-		return BackendURL
+func (h *HttpClient) baseURL() string {
+	if h.BaseURL != "" {
+		return h.BaseURL
 	}
+	return CurrentEndpoints().APIURL
 }
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHttpClient(serverURL string) *HttpClient {
+	return &HttpClient{Token: "abcd1234", BaseURL: serverURL, RetryBaseDelay: time.Millisecond}
+}
+
+func TestPerformRequestSuccess(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("Bearer abcd1234", r.Header.Get("Authorization"))
+		assert.Equal("/api/v1/decks", r.URL.Path)
+		w.Write([]byte(`{"decks":[]}`))
+	}))
+	defer server.Close()
+
+	body, statusCode, err := newTestHttpClient(server.URL).GetRequest("api/v1/decks")
+
+	assert.Nil(err)
+	assert.Equal(200, statusCode)
+	assert.Equal(`{"decks":[]}`, string(body))
+}
+
+func TestPerformRequestRetriesServerErrors(t *testing.T) {
+	assert := assert.New(t)
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	_, statusCode, err := newTestHttpClient(server.URL).GetRequest("api/v1/decks")
+
+	assert.Nil(err)
+	assert.Equal(200, statusCode)
+	assert.Equal(int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestPerformRequestGivesUpAfterMaxRetries(t *testing.T) {
+	assert := assert.New(t)
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"boom"}`))
+	}))
+	defer server.Close()
+
+	httpClient := newTestHttpClient(server.URL)
+	httpClient.MaxRetries = 2
+	_, statusCode, err := httpClient.PutRequest("api/v1/decks/1", []byte("{}"))
+
+	assert.Equal(500, statusCode)
+	assert.EqualError(err, "ultradeck.co had a problem (500): boom")
+	assert.Equal(int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestPerformRequestDoesNotRetryPostOnServerError(t *testing.T) {
+	assert := assert.New(t)
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	_, _, err := newTestHttpClient(server.URL).PostRequest("api/v1/decks", []byte("{}"))
+
+	assert.IsType(&ServerError{}, err)
+	assert.Equal(int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestPerformRequestRetriesPostOnTooManyRequests(t *testing.T) {
+	assert := assert.New(t)
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	_, statusCode, err := newTestHttpClient(server.URL).PostRequest("api/v1/decks", []byte("{}"))
+
+	assert.Nil(err)
+	assert.Equal(201, statusCode)
+	assert.Equal(int32(2), atomic.LoadInt32(&attempts))
+	assert.True(time.Since(start) >= time.Second, "should wait for Retry-After")
+}
+
+func TestPerformRequestDoesNotRetryClientErrors(t *testing.T) {
+	assert := assert.New(t)
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"Deck not found"}`))
+	}))
+	defer server.Close()
+
+	_, _, err := newTestHttpClient(server.URL).GetRequest("api/v1/decks/1")
+
+	assert.Equal(true, IsNotFound(err))
+	assert.Equal(int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestPerformRequestTimeout(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	httpClient := newTestHttpClient(server.URL)
+	httpClient.Timeout = 20 * time.Millisecond
+	httpClient.MaxRetries = -1
+	_, _, err := httpClient.GetRequest("api/v1/decks")
+
+	assert.IsType(&NetworkError{}, err)
+}
+
+func TestPerformRequestContextCancelled(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	httpClient := newTestHttpClient(server.URL)
+	httpClient.RetryBaseDelay = time.Hour
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, _, err := httpClient.PerformRequestContext(ctx, "api/v1/decks", "GET", nil)
+
+	assert.IsType(&NetworkError{}, err)
+	assert.True(time.Since(start) < time.Second, "should stop retrying once cancelled")
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert := assert.New(t)
+	httpClient := &HttpClient{RetryBaseDelay: 100 * time.Millisecond}
+
+	for attempt := 0; attempt < 4; attempt++ {
+		delay := httpClient.retryDelay(attempt, 0)
+		max := 100 * time.Millisecond << uint(attempt)
+		assert.True(delay >= max/2 && delay <= max, "delay %s out of range for attempt %d", delay, attempt)
+	}
+
+	delay := httpClient.retryDelay(20, 0)
+	assert.True(delay >= maxRetryDelay/2 && delay <= maxRetryDelay, "delay %s should be capped", delay)
+	assert.Equal(5*time.Second, httpClient.retryDelay(0, 5*time.Second))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(time.Duration(0), parseRetryAfter(""))
+	assert.Equal(3*time.Second, parseRetryAfter("3"))
+	assert.Equal(time.Duration(0), parseRetryAfter("soon"))
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	assert.True(parseRetryAfter(date) > 50*time.Second)
+}
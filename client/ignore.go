@@ -0,0 +1,232 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const IgnoreFile = ".udignore"
+
+// DefaultIgnorePatterns are always ignored, before the rules in .udignore,
+// which can un-ignore them with "!".
+var DefaultIgnorePatterns = []string{
+	// version control and dependencies
+	".git/",
+	".hg/",
+	".svn/",
+	"node_modules/",
+
+	// OS junk
+	".DS_Store",
+	"Thumbs.db",
+	"desktop.ini",
+
+	// editor swap, backup and lock files
+	"*.swp",
+	"*.swo",
+	"*.swx",
+	"*.swpx",
+	"*~",
+	".#*",
+	`\#*#`,
+	"4913",
+	"*.tmp",
+	"*.bak",
+	"*.crswap",
+}
+
+// IgnoreRules decides which files watch and asset discovery skip, using
+// the same rules as .gitignore: the last matching pattern wins, "!"
+// un-ignores, a trailing "/" only matches directories, and a pattern with a
+// "/" in it is relative to the deck directory rather than matching at any
+// depth.
+type IgnoreRules struct {
+	rules []*ignoreRule
+}
+
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// LoadIgnoreRules reads dir/.udignore, on top of the defaults.  A missing
+// file is fine.
+func LoadIgnoreRules(dir string) (*IgnoreRules, error) {
+	rules, _ := NewIgnoreRules(DefaultIgnorePatterns)
+
+	file, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return rules, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return rules, err
+	}
+
+	if err := rules.Add(patterns...); err != nil {
+		return rules, fmt.Errorf("%s: %s", IgnoreFile, err)
+	}
+	return rules, nil
+}
+
+// LoadIgnoreRulesOrDefault is LoadIgnoreRules, falling back to just the
+// defaults if .udignore can't be read, and saying why on warnings.
+func LoadIgnoreRulesOrDefault(dir string, warnings io.Writer) *IgnoreRules {
+	rules, err := LoadIgnoreRules(dir)
+	if err != nil {
+		fmt.Fprintln(warnings, "Could not read ignore rules:", err)
+	}
+	return rules
+}
+
+func NewIgnoreRules(patterns []string) (*IgnoreRules, error) {
+	rules := &IgnoreRules{}
+	return rules, rules.Add(patterns...)
+}
+
+// Add adds patterns, in .gitignore syntax.  Blank lines and comments are
+// skipped.
+func (r *IgnoreRules) Add(patterns ...string) error {
+	for _, pattern := range patterns {
+		rule, err := parseIgnoreRule(pattern)
+		if err != nil {
+			return err
+		}
+		if rule != nil {
+			r.rules = append(r.rules, rule)
+		}
+	}
+	return nil
+}
+
+// Ignored is true if path, relative to the deck directory, is ignored.
+// Anything inside an ignored directory is ignored too.
+func (r *IgnoreRules) Ignored(path string, isDir bool) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." {
+		return false
+	}
+
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if r.matches(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return r.matches(path, isDir)
+}
+
+func (r *IgnoreRules) matches(path string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(path) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func parseIgnoreRule(line string) (*ignoreRule, error) {
+	// trailing spaces don't count, unless escaped
+	pattern := strings.TrimRight(line, " ")
+	if strings.HasSuffix(pattern, "\\") && len(pattern) < len(line) {
+		pattern += " "
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil, nil
+	}
+
+	rule := &ignoreRule{}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if pattern == "" {
+		return nil, nil
+	}
+
+	compiled, err := compileIgnorePattern(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern %q: %s", line, err)
+	}
+	rule.pattern = compiled
+	return rule, nil
+}
+
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+
+	// without a slash, the pattern matches a name at any depth
+	if strings.Contains(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else {
+		re.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				// "**/" is any number of directories, a trailing "/**"
+				// everything inside
+				if i+2 < len(pattern) && pattern[i+2] == '/' {
+					re.WriteString("(?:.*/)?")
+					i += 2
+				} else {
+					re.WriteString(".*")
+					i++
+				}
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			re.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
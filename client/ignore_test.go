@@ -0,0 +1,100 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreRulesDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	rules, err := NewIgnoreRules(DefaultIgnorePatterns)
+	assert.Nil(err)
+
+	assert.True(rules.Ignored(".git", true))
+	assert.True(rules.Ignored(".git/HEAD", false))
+	assert.True(rules.Ignored("node_modules/left-pad/index.js", false))
+	assert.True(rules.Ignored("images/.DS_Store", false))
+	assert.True(rules.Ignored(".deck.md.swp", false))
+	assert.True(rules.Ignored("deck.md~", false))
+	assert.True(rules.Ignored("./.#deck.md", false))
+	assert.True(rules.Ignored("#deck.md#", false))
+	assert.True(rules.Ignored("4913", false))
+
+	assert.False(rules.Ignored("deck.md", false))
+	assert.False(rules.Ignored("cat.png", false))
+	assert.False(rules.Ignored(".", true))
+}
+
+func TestIgnoreRulesGitignoreSemantics(t *testing.T) {
+	assert := assert.New(t)
+
+	rules, err := NewIgnoreRules([]string{
+		"# comment",
+		"",
+		"*.psd",
+		"!keep.psd",
+		"/drafts",
+		"build/",
+		"docs/*.png",
+		"**/tmp/**",
+		"photo?.jpg",
+		"scan[0-9].png",
+		"raw[!a-z].png",
+		`\#notes.md`,
+	})
+	assert.Nil(err)
+
+	assert.True(rules.Ignored("cover.psd", false))
+	assert.True(rules.Ignored("images/cover.psd", false))
+	assert.False(rules.Ignored("keep.psd", false), "negated")
+
+	assert.True(rules.Ignored("drafts", true))
+	assert.True(rules.Ignored("drafts/one.md", false))
+	assert.False(rules.Ignored("images/drafts", true), "anchored to the deck directory")
+
+	assert.True(rules.Ignored("build", true))
+	assert.True(rules.Ignored("images/build/out.png", false))
+	assert.False(rules.Ignored("build", false), "only directories")
+
+	assert.True(rules.Ignored("docs/a.png", false))
+	assert.False(rules.Ignored("docs/sub/a.png", false), "* doesn't cross directories")
+
+	assert.True(rules.Ignored("a/b/tmp/c/d.png", false))
+
+	assert.True(rules.Ignored("photo1.jpg", false))
+	assert.False(rules.Ignored("photo10.jpg", false))
+	assert.True(rules.Ignored("scan3.png", false))
+	assert.False(rules.Ignored("scanx.png", false))
+	assert.True(rules.Ignored("raw1.png", false))
+	assert.False(rules.Ignored("rawx.png", false))
+	assert.True(rules.Ignored("#notes.md", false))
+}
+
+func TestIgnoreRulesCantReincludeInsideIgnoredDirectory(t *testing.T) {
+	assert := assert.New(t)
+
+	rules, _ := NewIgnoreRules([]string{"assets/", "!assets/logo.png"})
+	assert.True(rules.Ignored("assets/logo.png", false))
+}
+
+func TestLoadIgnoreRules(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "udignore")
+	defer os.RemoveAll(dir)
+
+	rules, err := LoadIgnoreRules(dir)
+	assert.Nil(err)
+	assert.True(rules.Ignored(".git", true), "defaults without a .udignore")
+
+	ioutil.WriteFile(filepath.Join(dir, IgnoreFile), []byte("*.psd\n!*.bak\n"), 0644)
+	rules, err = LoadIgnoreRules(dir)
+	assert.Nil(err)
+	assert.True(rules.Ignored("cover.psd", false))
+	assert.False(rules.Ignored("deck.bak", false), "defaults can be overridden")
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// JournalFile keeps slide changes that haven't made it to ultradeck.co yet,
+// next to .ud.json
+const JournalFile = ".ud-journal.json"
+
+// Journal is the queue of changes push couldn't send, e.g. because we were
+// offline.  They're replayed in order.  BaseUpdatedAt is the updated_at of
+// the deck on the server that the first entry was made against.
+type Journal struct {
+	BaseUpdatedAt string          `json:"base_updated_at"`
+	Entries       []*JournalEntry `json:"entries"`
+
+	// the deck directory the journal is in
+	dir string
+}
+
+type JournalEntry struct {
+	RecordedAt string     `json:"recorded_at"`
+	Ops        []*SlideOp `json:"ops"`
+}
+
+// JournalConflictError is returned when slides changed in the journal have
+// also changed on ultradeck.co.  SlideUUIDs is empty if the server can't
+// say which slides changed.
+type JournalConflictError struct {
+	SlideUUIDs []string
+}
+
+func (e *JournalConflictError) Error() string {
+	what := "the deck"
+	if len(e.SlideUUIDs) == 1 {
+		what = "a slide"
+	} else if len(e.SlideUUIDs) > 1 {
+		what = fmt.Sprintf("%d slides", len(e.SlideUUIDs))
+	}
+	return fmt.Sprintf("Changes you made while offline conflict with changes made to %s on ultradeck.co.\n"+
+		"Run 'ultradeck push --force' to overwrite them with yours, or 'ultradeck pull --force' to throw yours away.", what)
+}
+
+// ReadJournal reads the journal in the deck directory dir, or the working
+// directory if it's empty.  No journal file means an empty journal.
+func ReadJournal(dir string) (*Journal, error) {
+	journal := &Journal{dir: dir}
+
+	data, err := ioutil.ReadFile(journal.path())
+	if os.IsNotExist(err) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", JournalFile, err)
+	}
+
+	if err := json.Unmarshal(data, journal); err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", JournalFile, err)
+	}
+	return journal, nil
+}
+
+// Write saves the journal, or removes the file if it's empty.  The file is
+// replaced in one go, so a crash never leaves half a journal behind.
+func (j *Journal) Write() error {
+	if j.Empty() {
+		if err := os.Remove(j.path()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, _ := json.MarshalIndent(j, "", "  ")
+	tmpFile := j.path() + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %s", JournalFile, err)
+	}
+	if err := os.Rename(tmpFile, j.path()); err != nil {
+		return fmt.Errorf("could not write %s: %s", JournalFile, err)
+	}
+	return nil
+}
+
+func (j *Journal) path() string {
+	return filepath.Join(j.dir, JournalFile)
+}
+
+// Append queues ops made against the deck as of baseUpdatedAt.
+func (j *Journal) Append(baseUpdatedAt string, ops []*SlideOp) {
+	if j.Empty() {
+		j.BaseUpdatedAt = baseUpdatedAt
+	}
+	j.Entries = append(j.Entries, &JournalEntry{
+		RecordedAt: time.Now().UTC().Format(time.RFC3339),
+		Ops:        ops,
+	})
+}
+
+func (j *Journal) Empty() bool {
+	return len(j.Entries) == 0
+}
+
+// the slides the journal changes
+func (j *Journal) slideUUIDs() map[string]bool {
+	uuids := map[string]bool{}
+	for _, entry := range j.Entries {
+		for _, op := range entry.Ops {
+			uuids[op.UUID] = true
+		}
+	}
+	return uuids
+}
+
+// Conflicts returns the slides both the journal and remote change, sorted.
+func (j *Journal) Conflicts(remote *DeckChanges) []string {
+	ours := j.slideUUIDs()
+
+	seen := map[string]bool{}
+	var conflicts []string
+	for _, op := range remote.Ops {
+		if ours[op.UUID] && !seen[op.UUID] {
+			seen[op.UUID] = true
+			conflicts = append(conflicts, op.UUID)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// Without returns remote without the ops on slides the journal changes, so
+// they can be applied without undoing the journal's changes.
+func (j *Journal) Without(remote *DeckChanges) *DeckChanges {
+	ours := j.slideUUIDs()
+
+	filtered := *remote
+	filtered.Ops = nil
+	for _, op := range remote.Ops {
+		if !ours[op.UUID] {
+			filtered.Ops = append(filtered.Ops, op)
+		}
+	}
+	return &filtered
+}
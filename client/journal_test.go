@@ -0,0 +1,109 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func inTempDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	defer inTempDir(t)()
+
+	journal, err := ReadJournal("")
+	assert.Nil(err)
+	assert.True(journal.Empty(), "no file means an empty journal")
+
+	journal.Append("2018-01-01T00:00:00.000Z", []*SlideOp{{Op: UpdateSlideOp, UUID: "a", Slide: &Slide{Markdown: "# one"}}})
+	journal.Append("2018-01-02T00:00:00.000Z", []*SlideOp{{Op: RemoveSlideOp, UUID: "b"}})
+	assert.Nil(journal.Write())
+
+	journal, err = ReadJournal("")
+	assert.Nil(err)
+	assert.Equal("2018-01-01T00:00:00.000Z", journal.BaseUpdatedAt, "the base is where the first entry started")
+	assert.Equal(2, len(journal.Entries))
+	assert.Equal("# one", journal.Entries[0].Ops[0].Slide.Markdown)
+	assert.Equal(RemoveSlideOp, journal.Entries[1].Ops[0].Op)
+
+	journal.Entries = nil
+	assert.Nil(journal.Write())
+	_, err = os.Stat(JournalFile)
+	assert.True(os.IsNotExist(err), "an empty journal removes the file")
+}
+
+func TestJournalInDeckDirectory(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "journal")
+	defer os.RemoveAll(dir)
+
+	journal, err := ReadJournal(dir)
+	assert.Nil(err)
+	journal.Append("2018-01-01T00:00:00.000Z", []*SlideOp{{Op: RemoveSlideOp, UUID: "b"}})
+	assert.Nil(journal.Write())
+
+	_, err = os.Stat(filepath.Join(dir, JournalFile))
+	assert.Nil(err, "the journal is written to the deck directory, not the working directory")
+
+	journal, err = ReadJournal(dir)
+	assert.Nil(err)
+	assert.Equal(1, len(journal.Entries))
+}
+
+func TestReadJournalRejectsGarbage(t *testing.T) {
+	assert := assert.New(t)
+	defer inTempDir(t)()
+
+	ioutil.WriteFile(JournalFile, []byte("{not json"), 0644)
+	_, err := ReadJournal("")
+	assert.NotNil(err)
+}
+
+func TestJournalConflicts(t *testing.T) {
+	assert := assert.New(t)
+
+	journal := &Journal{}
+	journal.Append("", []*SlideOp{{Op: UpdateSlideOp, UUID: "b"}, {Op: UpdateSlideOp, UUID: "a"}})
+
+	remote := &DeckChanges{UpdatedAt: "2018-01-03T00:00:00.000Z", Ops: []*SlideOp{
+		{Op: UpdateSlideOp, UUID: "a"},
+		{Op: MoveSlideOp, UUID: "a"},
+		{Op: UpdateSlideOp, UUID: "b"},
+		{Op: AddSlideOp, UUID: "c"},
+	}}
+	assert.Equal([]string{"a", "b"}, journal.Conflicts(remote))
+
+	without := journal.Without(remote)
+	assert.Equal(1, len(without.Ops))
+	assert.Equal("c", without.Ops[0].UUID)
+	assert.Equal(remote.UpdatedAt, without.UpdatedAt)
+	assert.Equal(4, len(remote.Ops), "remote is left alone")
+
+	assert.Empty(journal.Conflicts(&DeckChanges{Ops: []*SlideOp{{Op: AddSlideOp, UUID: "c"}}}))
+}
+
+func TestJournalConflictErrorMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Contains((&JournalConflictError{}).Error(), "the deck")
+	assert.Contains((&JournalConflictError{SlideUUIDs: []string{"a"}}).Error(), "a slide")
+	assert.Contains((&JournalConflictError{SlideUUIDs: []string{"a", "b"}}).Error(), "2 slides")
+	assert.Contains((&JournalConflictError{}).Error(), "push --force")
+	assert.Contains((&JournalConflictError{}).Error(), "pull --force")
+}
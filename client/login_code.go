@@ -0,0 +1,26 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// LoginCode is a short-lived, single-use code that signs the user in on
+// ultradeck.co.  Links we open in the browser carry one of these instead of
+// the bearer token, so the token never ends up in browser history or logs.
+type LoginCode struct {
+	Code      string `json:"code"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func parseLoginCode(bodyBytes []byte) (*LoginCode, error) {
+	loginCode := &LoginCode{}
+	if err := json.Unmarshal(bodyBytes, loginCode); err != nil {
+		return nil, fmt.Errorf("unexpected response from ultradeck.co: %s", truncateBody(bodyBytes))
+	}
+
+	if loginCode.Code == "" {
+		return nil, fmt.Errorf("login code response from ultradeck.co is missing the code")
+	}
+	return loginCode, nil
+}
@@ -0,0 +1,25 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLoginCode(t *testing.T) {
+	assert := assert.New(t)
+
+	loginCode, err := parseLoginCode([]byte(`{"code":"xyz789","expires_at":"2018-06-01T12:00:00Z"}`))
+
+	assert.Nil(err)
+	assert.Equal("xyz789", loginCode.Code)
+}
+
+func TestParseLoginCodeMissingCode(t *testing.T) {
+	assert := assert.New(t)
+
+	loginCode, err := parseLoginCode([]byte(`{"code":null}`))
+
+	assert.Nil(loginCode)
+	assert.EqualError(err, "login code response from ultradeck.co is missing the code")
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ProtocolVersion is the version of the websocket message format we speak.
+// Messages without a version come from servers that predate versioning.
+const ProtocolVersion = 1
+
+// MessageKind is what a websocket message is about.  It's sent as the
+// "request" field.
+type MessageKind string
+
+const (
+	// sent with an AuthJson, once the user has signed in
+	AuthCompleteMessage MessageKind = "auth_complete"
+	DeckUpdatedMessage  MessageKind = "deck_updated"
+	SlideUpdatedMessage MessageKind = "slide_updated"
+	PongMessage         MessageKind = "pong"
+	ErrorMessage        MessageKind = "error"
+	PresenceMessage     MessageKind = "presence"
+
+	RegisterListenerRequest MessageKind = "register_listener"
+)
+
+const OkResponse = "ok"
+
+// Request is a message sent or received over the websocket.  Data is
+// decoded according to Kind, with DecodeData.
+type Request struct {
+	Version  int             `json:"version,omitempty"`
+	ClientID string          `json:"client_id"`
+	Channel  string          `json:"channel"`
+	Kind     MessageKind     `json:"request"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// sent with register_listener.  Servers that know about DeckUUIDs only
+// send messages about those decks, rather than about every deck on the
+// channel.
+type RegisterListener struct {
+	DeckUUIDs []string `json:"deck_uuids,omitempty"`
+}
+
+// sent with deck_updated, when someone saves the deck
+type DeckUpdated struct {
+	DeckID    int    `json:"deck_id"`
+	DeckUUID  string `json:"deck_uuid"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// sent with slide_updated, when someone changes a single slide
+type SlideUpdated struct {
+	DeckID    int    `json:"deck_id"`
+	DeckUUID  string `json:"deck_uuid"`
+	SlideID   int    `json:"slide_id"`
+	SlideUUID string `json:"slide_uuid"`
+	Position  int    `json:"position"`
+}
+
+// sent with error, when the server couldn't handle something we sent
+type RemoteError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RemoteError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// sent with presence, listing who else has the deck open
+type Presence struct {
+	DeckUUID string          `json:"deck_uuid"`
+	Users    []*PresenceUser `json:"users"`
+}
+
+type PresenceUser struct {
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	ClientID string `json:"client_id"`
+}
+
+func NewRequest(kind MessageKind, clientID string, channel string, data interface{}) (*Request, error) {
+	req := &Request{Version: ProtocolVersion, Kind: kind, ClientID: clientID, Channel: channel}
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode %s message: %s", kind, err)
+		}
+		req.Data = encoded
+	}
+	return req, nil
+}
+
+func DecodeRequest(message []byte) (*Request, error) {
+	req := &Request{}
+	if err := json.Unmarshal(message, req); err != nil {
+		return nil, fmt.Errorf("could not read message: %s", err)
+	}
+	if req.Version > ProtocolVersion {
+		return nil, fmt.Errorf("unsupported message version %d (we speak %d); try upgrading ultradeck", req.Version, ProtocolVersion)
+	}
+	return req, nil
+}
+
+// DecodeData unmarshals the message's data into v, e.g. a *DeckUpdated.
+func (r *Request) DecodeData(v interface{}) error {
+	if len(r.Data) == 0 {
+		return fmt.Errorf("%s message has no data", r.Kind)
+	}
+	if err := json.Unmarshal(r.Data, v); err != nil {
+		return fmt.Errorf("could not read %s message: %s", r.Kind, err)
+	}
+	return nil
+}
+
+// DeckUUID is the deck the message is about, or "" if it doesn't say, e.g.
+// because it comes from an older server.
+func (r *Request) DeckUUID() string {
+	var about struct {
+		DeckUUID string `json:"deck_uuid"`
+	}
+	if len(r.Data) == 0 || json.Unmarshal(r.Data, &about) != nil {
+		return ""
+	}
+	return about.DeckUUID
+}
+
+// MessageHandler handles one kind of message from the server.
+type MessageHandler func(req *Request) error
+
+// MessageHandlers says what handles each kind of message.  The zero value
+// handles nothing.
+type MessageHandlers struct {
+	mutex          sync.Mutex
+	handlers       map[MessageKind]MessageHandler
+	defaultHandler MessageHandler
+}
+
+// Handle registers handler for messages of the given kind, replacing any
+// previous handler for it.
+func (h *MessageHandlers) Handle(kind MessageKind, handler MessageHandler) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.handlers == nil {
+		h.handlers = map[MessageKind]MessageHandler{}
+	}
+	h.handlers[kind] = handler
+}
+
+// HandleDefault registers a handler for messages no other handler takes,
+// which includes everything from servers that predate typed messages.
+func (h *MessageHandlers) HandleDefault(handler MessageHandler) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.defaultHandler = handler
+}
+
+// Dispatch passes req to the handler registered for its kind.  Messages
+// nothing handles are dropped.
+func (h *MessageHandlers) Dispatch(req *Request) error {
+	h.mutex.Lock()
+	handler, ok := h.handlers[req.Kind]
+	if !ok {
+		handler = h.defaultHandler
+	}
+	h.mutex.Unlock()
+
+	if handler == nil {
+		DebugMsg(fmt.Sprintf("no handler for %q message", req.Kind))
+		return nil
+	}
+	return handler(req)
+}
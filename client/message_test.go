@@ -0,0 +1,117 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRequestIsVersioned(t *testing.T) {
+	assert := assert.New(t)
+
+	req, err := NewRequest(DeckUpdatedMessage, "client-1", "channel-1", &DeckUpdated{DeckID: 4, DeckUUID: "abc"})
+	assert.Nil(err)
+
+	encoded, _ := json.Marshal(req)
+	decoded := map[string]interface{}{}
+	json.Unmarshal(encoded, &decoded)
+
+	assert.Equal(float64(ProtocolVersion), decoded["version"])
+	assert.Equal("deck_updated", decoded["request"])
+	assert.Equal("client-1", decoded["client_id"])
+	assert.Equal("abc", decoded["data"].(map[string]interface{})["deck_uuid"])
+}
+
+func TestDecodeRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	req, err := DecodeRequest([]byte(`{"version":1,"request":"slide_updated","client_id":"c","data":{"deck_id":4,"slide_id":9,"position":2}}`))
+	assert.Nil(err)
+	assert.Equal(SlideUpdatedMessage, req.Kind)
+
+	slide := &SlideUpdated{}
+	assert.Nil(req.DecodeData(slide))
+	assert.Equal(4, slide.DeckID)
+	assert.Equal(9, slide.SlideID)
+	assert.Equal(2, slide.Position)
+}
+
+func TestDecodeLegacyRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	req, err := DecodeRequest([]byte(`{"request":"update","client_id":"c","data":{"token":"t"}}`))
+	assert.Nil(err)
+	assert.Equal(0, req.Version)
+	assert.Equal(MessageKind("update"), req.Kind)
+}
+
+func TestDecodeRequestFromNewerProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := DecodeRequest([]byte(`{"version":99,"request":"deck_updated"}`))
+	assert.NotNil(err)
+}
+
+func TestDecodeDataWithoutData(t *testing.T) {
+	assert := assert.New(t)
+
+	req := &Request{Kind: PresenceMessage}
+	assert.NotNil(req.DecodeData(&Presence{}))
+}
+
+func TestDispatch(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &WebsocketConnection{}
+	var handled []string
+	c.Handle(PongMessage, func(req *Request) error {
+		handled = append(handled, "pong")
+		return nil
+	})
+	c.Handle(ErrorMessage, func(req *Request) error {
+		return errors.New("boom")
+	})
+
+	// nothing handles it yet, so it's dropped
+	assert.Nil(c.Dispatch(&Request{Kind: "update"}))
+
+	c.HandleDefault(func(req *Request) error {
+		handled = append(handled, "default:"+string(req.Kind))
+		return nil
+	})
+
+	assert.Nil(c.Dispatch(&Request{Kind: PongMessage}))
+	assert.Nil(c.Dispatch(&Request{Kind: "update"}))
+	assert.Equal("boom", c.Dispatch(&Request{Kind: ErrorMessage}).Error())
+	assert.Equal([]string{"pong", "default:update"}, handled)
+}
+
+func TestRequestDeckUUID(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := NewRequest(DeckUpdatedMessage, "client-1", "channel-1", &DeckUpdated{DeckUUID: "deck-1"})
+	assert.Equal("deck-1", req.DeckUUID())
+
+	req, _ = NewRequest(PresenceMessage, "client-1", "channel-1", &Presence{DeckUUID: "deck-2"})
+	assert.Equal("deck-2", req.DeckUUID())
+
+	assert.Equal("", (&Request{Kind: "update"}).DeckUUID(), "older servers don't say")
+	assert.Equal("", (&Request{Kind: "update", Data: []byte(`"not an object"`)}).DeckUUID())
+}
+
+func TestMessageHandlersOnTheirOwn(t *testing.T) {
+	assert := assert.New(t)
+
+	handlers := &MessageHandlers{}
+	assert.Nil(handlers.Dispatch(&Request{Kind: PongMessage}))
+
+	handled := false
+	handlers.Handle(PongMessage, func(req *Request) error {
+		handled = true
+		return nil
+	})
+	assert.Nil(handlers.Dispatch(&Request{Kind: PongMessage}))
+	assert.True(handled)
+}
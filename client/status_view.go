@@ -0,0 +1,271 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+const (
+	maxRecentErrors = 5
+	maxRecentLog    = 8
+)
+
+// WatchStatus is what watch is up to, for the dashboard
+type WatchStatus struct {
+	Deck          string
+	Direction     string
+	Connection    ConnectionState
+	Pushing       bool
+	LastPush      time.Time
+	LastPushErr   error
+	Pulling       bool
+	LastPull      time.Time
+	LastPullErr   error
+	Pending       bool
+	Slides        int
+	Uploading     []string
+	Collaborators []string
+	Errors        []string
+	Log           []string
+}
+
+// StatusView shows watch's status, either as a dashboard that redraws in
+// place, or as plain log lines when stdout isn't a terminal (or no
+// dashboard was asked for).  It's also an io.Writer, for watch to send the
+// rest of its output to, so that ends up in the dashboard's log rather than
+// scribbling over it.  A nil *StatusView prints plain lines.
+type StatusView struct {
+	// Label goes before each log line, to tell decks apart when watching
+	// several
+	Label string
+
+	mutex     sync.Mutex
+	status    WatchStatus
+	dashboard bool
+	out       io.Writer
+
+	// whether the dashboard is up, between Start and Stop
+	running bool
+	stop    chan bool
+	stopped sync.WaitGroup
+
+	// what's been written since the last newline
+	partial []byte
+}
+
+func NewStatusView(deck string, wantDashboard bool) *StatusView {
+	dashboard := wantDashboard && isatty.IsTerminal(os.Stdout.Fd())
+	return &StatusView{
+		status:    WatchStatus{Deck: deck, Connection: Connected},
+		dashboard: dashboard,
+		out:       os.Stdout,
+	}
+}
+
+// Start brings up the dashboard, if there is one.
+func (v *StatusView) Start() {
+	if v == nil || !v.dashboard {
+		return
+	}
+
+	v.mutex.Lock()
+	v.running = true
+	v.stop = make(chan bool)
+	// switch to the alternate screen, so the dashboard doesn't end up in
+	// the scrollback
+	fmt.Fprint(v.out, "\x1b[?1049h\x1b[?25l")
+	v.mutex.Unlock()
+
+	v.stopped.Add(1)
+	go func() {
+		defer v.stopped.Done()
+		// redraw now and then, to keep the "ago"s up to date
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				v.redraw()
+			case <-v.stop:
+				return
+			}
+		}
+	}()
+
+	v.redraw()
+}
+
+// Stop takes the dashboard down.  Anything logged afterwards is printed as
+// plain lines.
+func (v *StatusView) Stop() {
+	if v == nil {
+		return
+	}
+
+	v.mutex.Lock()
+	if !v.running {
+		v.mutex.Unlock()
+		return
+	}
+	v.running = false
+	fmt.Fprint(v.out, "\x1b[?25h\x1b[?1049l")
+	v.mutex.Unlock()
+
+	close(v.stop)
+	v.stopped.Wait()
+}
+
+// Write logs p a line at a time; a line without its newline yet waits for
+// the rest.
+func (v *StatusView) Write(p []byte) (int, error) {
+	if v == nil {
+		return os.Stdout.Write(p)
+	}
+
+	v.mutex.Lock()
+	v.partial = append(v.partial, p...)
+	var lines []string
+	for {
+		i := bytes.IndexByte(v.partial, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, strings.TrimRight(string(v.partial[:i]), " \r"))
+		v.partial = v.partial[i+1:]
+	}
+	v.mutex.Unlock()
+
+	for _, line := range lines {
+		v.Update(line, nil)
+	}
+	return len(p), nil
+}
+
+// Update applies change to the status, and logs message if it isn't empty.
+func (v *StatusView) Update(message string, change func(status *WatchStatus)) {
+	if v == nil {
+		if message != "" {
+			fmt.Println(message)
+		}
+		return
+	}
+
+	if message != "" && v.Label != "" {
+		message = v.Label + ": " + message
+	}
+
+	v.mutex.Lock()
+	if change != nil {
+		change(&v.status)
+	}
+	running := v.running
+	if message != "" {
+		if running {
+			v.status.Log = appendRecent(v.status.Log, timestamped(message), maxRecentLog)
+		} else {
+			// under the lock, so it can't land in the middle of Stop
+			fmt.Fprintln(v.out, timestamped(message))
+		}
+	}
+	v.mutex.Unlock()
+
+	if running {
+		v.redraw()
+	}
+}
+
+// Error logs err, and keeps it in the dashboard's recent errors.
+func (v *StatusView) Error(err error) {
+	v.Update(err.Error(), func(status *WatchStatus) {
+		status.Errors = appendRecent(status.Errors, timestamped(err.Error()), maxRecentErrors)
+	})
+}
+
+func (v *StatusView) redraw() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if !v.running {
+		return
+	}
+	fmt.Fprint(v.out, "\x1b[H\x1b[2J")
+	v.status.Render(v.out, time.Now())
+}
+
+// Render draws the dashboard.
+func (s *WatchStatus) Render(w io.Writer, now time.Time) {
+	fmt.Fprintf(w, "ultradeck watch: %s\n\n", s.Deck)
+	row := func(label string, value string) {
+		fmt.Fprintf(w, "  %-15s %s\n", label+":", value)
+	}
+
+	row("Connection", s.Connection.String())
+	switch s.Direction {
+	case "up":
+		row("Direction", "only pushing")
+	case "down":
+		row("Direction", "only pulling")
+	}
+	row("Last push", syncResult(s.Pushing, "pushing", s.LastPush, s.LastPushErr, now))
+	row("Last pull", syncResult(s.Pulling, "pulling", s.LastPull, s.LastPullErr, now))
+	if s.Pending {
+		row("Pending", "changes waiting to be pushed")
+	} else {
+		row("Pending", "none")
+	}
+	row("Slides", fmt.Sprintf("%d", s.Slides))
+	row("Uploading", listOrNone(s.Uploading))
+	row("Collaborators", listOrNone(s.Collaborators))
+
+	if len(s.Errors) > 0 {
+		fmt.Fprintln(w, "\nRecent errors:")
+		for _, line := range s.Errors {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	if len(s.Log) > 0 {
+		fmt.Fprintln(w, "\nLog:")
+		for _, line := range s.Log {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	fmt.Fprintln(w, "\nPress Ctrl-C to stop.")
+}
+
+func syncResult(inProgress bool, doing string, last time.Time, err error, now time.Time) string {
+	switch {
+	case inProgress:
+		return doing + "..."
+	case last.IsZero():
+		return "never"
+	case err != nil:
+		return fmt.Sprintf("failed %s ago: %s", now.Sub(last).Truncate(time.Second), err)
+	}
+	return fmt.Sprintf("ok, %s ago", now.Sub(last).Truncate(time.Second))
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+func timestamped(message string) string {
+	return fmt.Sprintf("[%s] %s", time.Now().Format("15:04:05"), message)
+}
+
+func appendRecent(lines []string, line string, max int) []string {
+	lines = append(lines, line)
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return lines
+}
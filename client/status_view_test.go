@@ -0,0 +1,137 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchStatusRender(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	status := &WatchStatus{
+		Deck:          "My Deck",
+		Connection:    Reconnecting,
+		LastPush:      now.Add(-90 * time.Second),
+		LastPull:      now.Add(-5 * time.Second),
+		LastPullErr:   errors.New("boom"),
+		Pending:       true,
+		Slides:        12,
+		Uploading:     []string{"cat.png"},
+		Collaborators: []string{"Alice", "bob"},
+		Errors:        []string{"[10:00:00] push failed: nope"},
+	}
+
+	var out bytes.Buffer
+	status.Render(&out, now)
+	rendered := out.String()
+
+	assert.Contains(rendered, "ultradeck watch: My Deck")
+	assert.Contains(rendered, "Connection:     reconnecting")
+	assert.NotContains(rendered, "Direction:")
+	assert.Contains(rendered, "Last push:      ok, 1m30s ago")
+	assert.Contains(rendered, "Last pull:      failed 5s ago: boom")
+	assert.Contains(rendered, "Pending:        changes waiting to be pushed")
+	assert.Contains(rendered, "Slides:         12")
+	assert.Contains(rendered, "Uploading:      cat.png")
+	assert.Contains(rendered, "Collaborators:  Alice, bob")
+	assert.Contains(rendered, "push failed: nope")
+	assert.NotContains(rendered, "Log:")
+
+	status = &WatchStatus{Pushing: true}
+	out.Reset()
+	status.Render(&out, now)
+	assert.Contains(out.String(), "Last push:      pushing...")
+	assert.Contains(out.String(), "Last pull:      never")
+	assert.Contains(out.String(), "Uploading:      none")
+}
+
+func TestStatusViewPlainLines(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	view := &StatusView{out: &out}
+
+	view.Update("", func(status *WatchStatus) { status.Slides = 3 })
+	view.Update("connected", nil)
+	view.Error(errors.New("push failed"))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(2, len(lines))
+	assert.True(strings.HasSuffix(lines[0], "] connected"))
+	assert.True(strings.HasSuffix(lines[1], "] push failed"))
+	assert.Equal(3, view.status.Slides)
+	assert.Equal(1, len(view.status.Errors))
+}
+
+func TestWatchStatusRenderDirection(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	status := &WatchStatus{Deck: "My Deck", Direction: "down"}
+	status.Render(&out, time.Now())
+	assert.Contains(out.String(), "Direction:      only pulling")
+}
+
+func TestStatusViewLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	view := &StatusView{out: &out, Label: "talks/gophercon"}
+	view.Update("connected", nil)
+
+	assert.True(strings.HasSuffix(strings.TrimSpace(out.String()), "] talks/gophercon: connected"))
+}
+
+func TestAppendRecent(t *testing.T) {
+	assert := assert.New(t)
+
+	var lines []string
+	for _, line := range []string{"a", "b", "c", "d"} {
+		lines = appendRecent(lines, line, 3)
+	}
+	assert.Equal([]string{"b", "c", "d"}, lines)
+}
+
+func TestStatusViewWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	view := &StatusView{out: &out}
+
+	fmt.Fprint(view, "Pushing local changes")
+	assert.Equal("", out.String(), "a partial line waits for the rest")
+
+	fmt.Fprintln(view, " to ultradeck.co...")
+	fmt.Fprintln(view, "Done!")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(2, len(lines))
+	assert.True(strings.HasSuffix(lines[0], "] Pushing local changes to ultradeck.co..."))
+	assert.True(strings.HasSuffix(lines[1], "] Done!"))
+}
+
+func TestStatusViewDashboard(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	view := &StatusView{out: &out, dashboard: true}
+	view.Start()
+
+	fmt.Fprintln(view, "Pulling changes from ultradeck.co...")
+	assert.Contains(out.String(), "Log:")
+	assert.Equal(1, len(view.status.Log))
+
+	view.Stop()
+	view.Stop()
+	out.Reset()
+	fmt.Fprintln(view, "Pushing your last changes...")
+	assert.True(strings.HasSuffix(strings.TrimSpace(out.String()), "] Pushing your last changes..."), "after Stop, lines are printed")
+	assert.NotContains(out.String(), "Log:")
+}
@@ -1,12 +1,12 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/url"
-	"os"
-	"os/signal"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -17,124 +17,359 @@ const (
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
 	maxMessageSize = 1024 * 1024
+
+	reconnectBaseDelay = time.Second
+	maxReconnectDelay  = 30 * time.Second
 )
 
-const RegisterListenerRequest = "register_listener"
-const OkResponse = "ok"
+var errConnectionClosed = errors.New("the websocket connection is closed")
+
+type ConnectionState int
+
+const (
+	Connected ConnectionState = iota
+	Reconnecting
+	Disconnected
+)
 
-type Request struct {
-	ClientID string                 `json:"client_id"`
-	Channel  string                 `json:"channel"`
-	Request  string                 `json:"request"`
-	Data     map[string]interface{} `json:"data"`
+func (s ConnectionState) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	}
+	return "disconnected"
 }
 
 // Client is the client
 type WebsocketConnection struct {
-	Conn      *websocket.Conn
-	Done      chan bool
-	Interrupt chan os.Signal
-	ClientID  string
-	Channel   string
+	Conn     *websocket.Conn
+	Done     chan bool
+	ClientID string
+	Channel  string
+
+	// AutoReconnect makes Listen reconnect with backoff when the connection
+	// drops, rather than closing Done.  Listeners are registered again once
+	// reconnected.
+	AutoReconnect bool
+
+	// DeckUUIDs are the decks we want to hear about, if not every deck on
+	// the channel
+	DeckUUIDs []string
+
+	// StateChanges receives the new state whenever the connection drops or
+	// comes back.  It's buffered; changes nobody reads in time are dropped.
+	StateChanges chan ConnectionState
+
+	ctx       context.Context
+	connMutex sync.Mutex
+	doneOnce  sync.Once
+	pinging   bool
+
+	// every write goes through the writer goroutine, as gorilla/websocket
+	// doesn't allow concurrent writers
+	outgoing   chan *outgoingMessage
+	writerDone chan bool
+
+	// what handles each kind of message, for Dispatch
+	MessageHandlers
 }
 
-func NewWebsocketConnection(channel string) *WebsocketConnection {
+func NewWebsocketConnection(ctx context.Context, channel string) (*WebsocketConnection, error) {
 	uuid := NewUUID()
 
 	ws := &WebsocketConnection{ClientID: uuid, Channel: channel}
-	ws.OpenConnection()
-	return ws
+	if err := ws.OpenConnection(ctx); err != nil {
+		return nil, err
+	}
+	return ws, nil
 }
 
-func (c *WebsocketConnection) OpenConnection() {
-	c.Interrupt = make(chan os.Signal, 1)
-	signal.Notify(c.Interrupt, os.Interrupt)
+// OpenConnection dials the server.  Once ctx is cancelled the connection
+// stops reconnecting, but stays open until CloseConnection, so the caller
+// can finish up first.  With AutoReconnect set, failing to connect isn't an
+// error; Listen keeps trying instead.
+func (c *WebsocketConnection) OpenConnection(ctx context.Context) error {
+	c.ctx = ctx
+	c.Done = make(chan bool)
+	c.StateChanges = make(chan ConnectionState, 16)
+
+	if err := c.dial(); err != nil {
+		if !c.AutoReconnect {
+			return &NetworkError{Err: err}
+		}
+		DebugMsg(fmt.Sprintf("could not connect, will keep trying: %s", err))
+		c.setState(Reconnecting)
+	}
+
+	c.outgoing = make(chan *outgoingMessage)
+	c.writerDone = make(chan bool)
+	go c.writeLoop()
+	return nil
+}
 
+func (c *WebsocketConnection) dial() error {
 	DebugMsg(fmt.Sprintf("connecting to %s", c.serverURL()))
 
-	var err error
 	DebugMsg("Dialing...")
-	c.Conn, _, err = websocket.DefaultDialer.Dial(c.serverURL(), nil)
+	conn, err := c.dialUnlessCancelled()
 	if err != nil {
-		log.Fatal("Dial err:", err)
+		return err
 	}
 	DebugMsg("Dialed")
 
-	c.Done = make(chan bool)
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	c.connMutex.Lock()
+	c.Conn = conn
+	c.connMutex.Unlock()
+	return nil
+}
+
+type dialResult struct {
+	conn *websocket.Conn
+	err  error
+}
+
+// the vendored gorilla/websocket can't cancel a dial, so dial in the
+// background and give up on it when ctx is cancelled, closing the
+// connection if it turns up afterwards
+func (c *WebsocketConnection) dialUnlessCancelled() (*websocket.Conn, error) {
+	result := make(chan dialResult, 1)
+	go func() {
+		conn, _, err := c.dialer().Dial(c.serverURL(), nil)
+		result <- dialResult{conn: conn, err: err}
+	}()
+
+	select {
+	case r := <-result:
+		return r.conn, r.err
+	case <-c.ctx.Done():
+		go func() {
+			if r := <-result; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, c.ctx.Err()
+	}
 }
 
 func (c *WebsocketConnection) CloseConnection() {
-	err := c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	// mark done first, so Listen doesn't try to reconnect
+	c.markDone()
+
+	err := c.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 	if err != nil {
 		log.Println("write close err:", err)
-		return
 	}
-	c.Conn.Close()
-	close(c.Done)
+	if conn := c.currentConn(); conn != nil {
+		conn.Close()
+	}
+}
+
+// Done is closed rather than sent on, so that both a read error and an
+// explicit close can signal it without blocking or panicking.
+func (c *WebsocketConnection) markDone() {
+	c.doneOnce.Do(func() { close(c.Done) })
+}
+
+func (c *WebsocketConnection) isDone() bool {
+	select {
+	case <-c.Done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (c *WebsocketConnection) RegisterListener() {
-	req := &Request{
-		Request:  RegisterListenerRequest,
-		ClientID: c.ClientID,
-		Channel:  c.Channel,
+	if c.currentConn() == nil {
+		// registered once we manage to connect
+		return
+	}
+	var data interface{}
+	if len(c.DeckUUIDs) > 0 {
+		data = &RegisterListener{DeckUUIDs: c.DeckUUIDs}
 	}
+	req, _ := NewRequest(RegisterListenerRequest, c.ClientID, c.Channel, data)
 	authMsg, _ := json.Marshal(req)
 
-	err := c.Conn.WriteMessage(websocket.TextMessage, []byte(authMsg))
+	err := c.write(websocket.TextMessage, []byte(authMsg))
 	if err != nil {
 		log.Println("write err: ", err)
 	}
 }
 
 func (c *WebsocketConnection) Listen(rchan chan<- *Request) {
-	fmt.Println("Listening..")
+	DebugMsg("Listening..")
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		conn := c.currentConn()
+		if conn == nil {
+			// never connected in the first place
+			if c.reconnect() {
+				continue
+			}
+			c.setState(Disconnected)
+			c.markDone()
+			break
+		}
+		if c.isPinging() {
+			// the server answers our pings, so silence means the connection
+			// is gone, e.g. because the laptop went to sleep
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+		}
+
+		_, message, err := conn.ReadMessage()
 		DebugMsg("<Websocket> read message")
 
 		if err != nil {
-			c.Done <- true
-			log.Println("read error:", err)
+			DebugMsg(fmt.Sprintf("read error: %s", err))
+			if c.AutoReconnect && !c.isDone() && c.reconnect() {
+				continue
+			}
+			c.setState(Disconnected)
+			c.markDone()
 			break
 		}
 
-		req := &Request{}
-		json.Unmarshal(message, req)
+		req, err := DecodeRequest(message)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
 		rchan <- req
 	}
 }
 
+// dials again with backoff until it works, or the connection is closed or
+// its context cancelled.  Returns false if it gave up.
+func (c *WebsocketConnection) reconnect() bool {
+	if conn := c.currentConn(); conn != nil {
+		c.setState(Reconnecting)
+		conn.Close()
+	}
+
+	for attempt := 0; ; attempt++ {
+		timer := time.NewTimer(backoffDelay(reconnectBaseDelay, maxReconnectDelay, attempt))
+		select {
+		case <-c.Done:
+			timer.Stop()
+			return false
+		case <-c.ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+
+		if err := c.dial(); err != nil {
+			DebugMsg(fmt.Sprintf("reconnect failed: %s", err))
+			continue
+		}
+
+		c.RegisterListener()
+		c.setState(Connected)
+		return true
+	}
+}
+
+func (c *WebsocketConnection) setState(state ConnectionState) {
+	select {
+	case c.StateChanges <- state:
+	default:
+		DebugMsg("dropping connection state change: " + state.String())
+	}
+}
+
+// SetupPinger pings the server every pingPeriod, and treats the connection
+// as dead if no pong comes back within pongWait.
 func (c *WebsocketConnection) SetupPinger() {
 	DebugMsg("running SetupPinger")
-	req := &Request{
-		Request:  "ping",
-		ClientID: c.ClientID,
-		Channel:  c.Channel,
+	c.connMutex.Lock()
+	c.pinging = true
+	c.connMutex.Unlock()
+}
+
+func (c *WebsocketConnection) isPinging() bool {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	return c.pinging
+}
+
+type outgoingMessage struct {
+	messageType int
+	data        []byte
+	result      chan error
+}
+
+// write queues a message for the writer goroutine and waits until it's sent
+func (c *WebsocketConnection) write(messageType int, data []byte) error {
+	msg := &outgoingMessage{messageType: messageType, data: data, result: make(chan error, 1)}
+	select {
+	case c.outgoing <- msg:
+	case <-c.writerDone:
+		return errConnectionClosed
 	}
-	pingMsg, _ := json.Marshal(req)
+	return <-msg.result
+}
 
-	go func() {
-		for {
+// the only goroutine that writes to the connection.  It stops after sending
+// a close message.
+func (c *WebsocketConnection) writeLoop() {
+	defer close(c.writerDone)
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-c.outgoing:
+			msg.result <- c.writeNow(msg.messageType, msg.data)
+			if msg.messageType == websocket.CloseMessage {
+				return
+			}
+		case <-ticker.C:
+			if !c.isPinging() {
+				continue
+			}
 			DebugMsg("Sending ping")
-			err := c.Conn.WriteMessage(websocket.TextMessage, []byte(pingMsg))
-			if err != nil {
-				log.Println("write err: ", err)
-				break
+			// a failed ping isn't fatal; Listen notices the dead connection
+			// and reconnects, and the next ping goes out on the new one
+			if err := c.writeNow(websocket.PingMessage, nil); err != nil {
+				DebugMsg(fmt.Sprintf("ping failed: %s", err))
 			}
-			time.Sleep(20 * time.Second)
 		}
-	}()
+	}
 }
 
-func (c *WebsocketConnection) serverURL() string {
-	if os.Getenv("DEV_MODE") != "" {
-		addr := "localhost:8080"
-		u := url.URL{Scheme: "ws", Host: addr, Path: "/"}
-		return u.String()
-	} else {
-		addr := "ws.ultradeck.co"
-		u := url.URL{Scheme: "ws", Host: addr, Path: "/"}
-		return u.String()
+func (c *WebsocketConnection) writeNow(messageType int, data []byte) error {
+	conn := c.currentConn()
+	if conn == nil {
+		return errConnectionClosed
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteMessage(messageType, data)
+}
+
+func (c *WebsocketConnection) currentConn() *websocket.Conn {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	return c.Conn
+}
+
+// dials through the configured proxy, trusting the configured CA bundle
+func (c *WebsocketConnection) dialer() *websocket.Dialer {
+	endpoints := CurrentEndpoints()
+	return &websocket.Dialer{
+		Proxy:            endpoints.ProxyFunc(),
+		TLSClientConfig:  endpoints.TLSConfig(),
+		HandshakeTimeout: 45 * time.Second,
 	}
 }
+
+func (c *WebsocketConnection) serverURL() string {
+	return CurrentEndpoints().WebsocketURL
+}
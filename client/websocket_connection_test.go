@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionStateString(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("connected", Connected.String())
+	assert.Equal("reconnecting", Reconnecting.String())
+	assert.Equal("disconnected", Disconnected.String())
+}
+
+func TestSetStateDoesNotBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &WebsocketConnection{StateChanges: make(chan ConnectionState, 1)}
+	c.setState(Reconnecting)
+	c.setState(Connected)
+
+	assert.Equal(Reconnecting, <-c.StateChanges)
+	assert.Equal(0, len(c.StateChanges))
+}
+
+func newTestWebsocketServer(t *testing.T, handler func(conn *websocket.Conn)) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		handler(conn)
+	}))
+
+	SetEndpoints(&Endpoints{WebsocketURL: "ws" + strings.TrimPrefix(server.URL, "http"), AllowInsecure: true})
+	return server
+}
+
+func TestConcurrentWritesAreSerialized(t *testing.T) {
+	assert := assert.New(t)
+
+	received := make(chan int)
+	server := newTestWebsocketServer(t, func(conn *websocket.Conn) {
+		count := 0
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			req, _ := DecodeRequest(message)
+			if req != nil && req.Kind == RegisterListenerRequest {
+				count++
+			}
+		}
+		received <- count
+	})
+	defer server.Close()
+	defer SetEndpoints(nil)
+
+	c, err := NewWebsocketConnection(context.Background(), "channel-1")
+	assert.Nil(err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.RegisterListener()
+		}()
+	}
+	wg.Wait()
+	c.CloseConnection()
+
+	assert.Equal(50, <-received)
+	assert.Equal(errConnectionClosed, c.write(websocket.TextMessage, []byte("late")))
+}
+
+func TestOversizedMessagesCloseTheConnection(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestWebsocketServer(t, func(conn *websocket.Conn) {
+		conn.WriteMessage(websocket.TextMessage, make([]byte, maxMessageSize+1))
+		conn.ReadMessage()
+	})
+	defer server.Close()
+	defer SetEndpoints(nil)
+
+	c, err := NewWebsocketConnection(context.Background(), "channel-1")
+	assert.Nil(err)
+	go c.Listen(make(chan *Request))
+
+	select {
+	case <-c.Done:
+	case <-time.After(5 * time.Second):
+		assert.Fail("the connection should have been closed")
+	}
+}
+
+func TestCancellingStopsReconnecting(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestWebsocketServer(t, func(conn *websocket.Conn) {})
+	defer server.Close()
+	defer SetEndpoints(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := NewWebsocketConnection(ctx, "channel-1")
+	assert.Nil(err)
+	c.AutoReconnect = true
+	go c.Listen(make(chan *Request))
+
+	assert.Equal(Reconnecting, <-c.StateChanges)
+	cancel()
+
+	select {
+	case <-c.Done:
+	case <-time.After(5 * time.Second):
+		assert.Fail("cancelling should have stopped the reconnecting")
+	}
+}
+
+func TestAutoReconnectCanStartOffline(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestWebsocketServer(t, func(conn *websocket.Conn) {})
+	server.Close()
+	defer SetEndpoints(nil)
+
+	c := &WebsocketConnection{ClientID: NewUUID(), Channel: "channel-1", AutoReconnect: true}
+	assert.Nil(c.OpenConnection(context.Background()))
+	assert.Equal(Reconnecting, <-c.StateChanges)
+
+	go c.Listen(make(chan *Request))
+	c.CloseConnection()
+
+	select {
+	case <-c.Done:
+	case <-time.After(5 * time.Second):
+		assert.Fail("closing should have stopped the reconnecting")
+	}
+}
+
+func TestOpenConnectionFailsOfflineWithoutAutoReconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestWebsocketServer(t, func(conn *websocket.Conn) {})
+	server.Close()
+	defer SetEndpoints(nil)
+
+	_, err := NewWebsocketConnection(context.Background(), "channel-1")
+	assert.IsType(&NetworkError{}, err)
+}
+
+func TestRegisterListenerSubscribesToDecks(t *testing.T) {
+	assert := assert.New(t)
+
+	registered := make(chan *Request, 2)
+	server := newTestWebsocketServer(t, func(conn *websocket.Conn) {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if req, _ := DecodeRequest(message); req != nil && req.Kind == RegisterListenerRequest {
+				registered <- req
+			}
+		}
+	})
+	defer server.Close()
+	defer SetEndpoints(nil)
+
+	c, err := NewWebsocketConnection(context.Background(), "channel-1")
+	assert.Nil(err)
+	defer c.CloseConnection()
+
+	c.RegisterListener()
+	assert.Empty((<-registered).Data, "every deck on the channel")
+
+	c.DeckUUIDs = []string{"deck-1", "deck-2"}
+	c.RegisterListener()
+	subscription := &RegisterListener{}
+	assert.Nil((<-registered).DecodeData(subscription))
+	assert.Equal([]string{"deck-1", "deck-2"}, subscription.DeckUUIDs)
+}
+
+func TestCancellingStopsAHangingDial(t *testing.T) {
+	assert := assert.New(t)
+
+	// accepts the connection but never answers the handshake
+	hang := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer server.Close()
+	defer close(hang)
+	SetEndpoints(&Endpoints{WebsocketURL: "ws" + strings.TrimPrefix(server.URL, "http"), AllowInsecure: true})
+	defer SetEndpoints(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	started := time.Now()
+	_, err := NewWebsocketConnection(ctx, "channel-1")
+	assert.NotNil(err)
+	assert.True(time.Since(started) < 5*time.Second)
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WriteTracker remembers what we wrote to files ourselves, e.g. when
+// pulling, so the file events those writes cause can be told apart from
+// changes the user made.  Relative paths are relative to the working
+// directory.
+type WriteTracker struct {
+	mutex  sync.Mutex
+	hashes map[string]string
+}
+
+// Record remembers the current content of each path.
+func (w *WriteTracker) Record(paths ...string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.hashes == nil {
+		w.hashes = map[string]string{}
+	}
+	for _, path := range paths {
+		hash, ok := fileHash(path)
+		if !ok {
+			continue
+		}
+		w.hashes[absPath(path)] = hash
+	}
+}
+
+// WriteFile replaces path with data in one go, recording data first, so
+// that none of the file events the write causes look like the user's.  A
+// nil *WriteTracker just writes.
+func (w *WriteTracker) WriteFile(path string, data []byte) error {
+	if w != nil {
+		sum := sha256.Sum256(data)
+		w.mutex.Lock()
+		if w.hashes == nil {
+			w.hashes = map[string]string{}
+		}
+		w.hashes[absPath(path)] = hex.EncodeToString(sum[:])
+		w.mutex.Unlock()
+	}
+
+	tmpFile := path + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, path)
+}
+
+// IsOwnWrite is true if path still has the content it had when it was last
+// recorded.  Once the user changes it, it stops being ours.
+func (w *WriteTracker) IsOwnWrite(path string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	key := absPath(path)
+	recorded, ok := w.hashes[key]
+	if !ok {
+		return false
+	}
+
+	hash, ok := fileHash(path)
+	if ok && hash == recorded {
+		return true
+	}
+	delete(w.hashes, key)
+	return false
+}
+
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return abs
+}
+
+func fileHash(path string) (string, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), true
+}
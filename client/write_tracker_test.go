@@ -0,0 +1,61 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTracker(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "write-tracker")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deck.md")
+
+	tracker := &WriteTracker{}
+	assert.False(tracker.IsOwnWrite(path))
+
+	ioutil.WriteFile(path, []byte("# Pulled"), 0644)
+	tracker.Record(path)
+	assert.True(tracker.IsOwnWrite(path))
+	assert.True(tracker.IsOwnWrite(filepath.Join(dir, ".", "deck.md")))
+
+	// the user edits it
+	ioutil.WriteFile(path, []byte("# Edited"), 0644)
+	assert.False(tracker.IsOwnWrite(path))
+
+	// and changing it back is still the user's change
+	ioutil.WriteFile(path, []byte("# Pulled"), 0644)
+	assert.False(tracker.IsOwnWrite(path))
+}
+
+func TestWriteTrackerMissingFile(t *testing.T) {
+	assert := assert.New(t)
+
+	tracker := &WriteTracker{}
+	tracker.Record("does-not-exist.md")
+	assert.False(tracker.IsOwnWrite("does-not-exist.md"))
+}
+
+func TestWriteTrackerRelativeToWorkingDirectory(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "write-tracker")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "deck.md"), []byte("# Pulled"), 0644)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	tracker := &WriteTracker{}
+	os.Chdir(dir)
+	tracker.Record("deck.md")
+	os.Chdir(wd)
+
+	assert.True(tracker.IsOwnWrite(filepath.Join(dir, "deck.md")))
+	assert.False(tracker.IsOwnWrite("deck.md"), "a different deck.md")
+}
@@ -1,44 +1,162 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/gammons/ultradeck-cli/client"
 	"github.com/manifoldco/promptui"
+	"github.com/mattn/go-isatty"
 	"github.com/skratchdot/open-golang/open"
 )
 
 const Version = "0.2"
 
+// exit codes, so scripts can tell what kind of failure happened
 const (
-	FrontendURL    = "https://app.ultradeck.co"
-	BackendURL     = "https://api.ultradeck.co"
-	DevFrontendURL = "http://localhost:3000"
-	DevBackendURL  = "http://localhost:3001"
+	exitError        = 1
+	exitNetworkError = 2
+	exitRequestError = 3
+	exitServerError  = 4
+	exitInterrupted  = 130
+)
+
+var (
+	errNoAuthConfig = errors.New("No auth config file found!\nPlease run 'ultradeck auth' to log in.")
+	errNoDeckConfig = errors.New("Could not find deck config!\nDid you run 'ultradeck create' or 'ultradeck import' yet?")
+	errSignedOut    = errors.New("It does not look like you're signed in anymore.\nPlease run 'ultradeck auth' to sign in again.")
+	errInterrupted  = errors.New("Interrupted.")
+	errLocalChanges = errors.New("It looks like you might have local changes that are not on the server!\n" +
+		"Did you make changes to your deck elsewhere, or on ultradeck.co?\n" +
+		"To throw away your local changes and take the deck from ultradeck.co, run 'ultradeck pull --force'.")
+	errJournalPending = errors.New("You have changes that haven't been pushed to ultradeck.co yet.\n" +
+		"Run 'ultradeck push' first, so they aren't overwritten, or 'ultradeck pull --force' to throw them away.")
+	errSignInTimedOut = errors.New("Gave up waiting for you to sign in again.")
+
+	// what watch --direction=down's push queue gets instead of a push
+	errNotPushing = errors.New("not pushing")
 )
 
 type Client struct {
 	Conn     *client.WebsocketConnection
 	ClientID string
+
+	// cancelled when a long-running command should stop, e.g. on Ctrl-C
+	Context context.Context
+
+	// the deck's directory in watch; empty for the working directory
+	dir string
+
+	// held while pushing or pulling the deck, as watch does both in the
+	// background
+	deckMutex sync.Mutex
+
+	// which way watch syncs: syncUp, syncDown or syncBoth
+	direction string
+
+	// files pull wrote, so watch doesn't push them straight back
+	ownWrites client.WriteTracker
+
+	// what watch doesn't react to, from .udignore
+	ignore *client.IgnoreRules
+
+	// what watch shows about itself; nil outside watch
+	status *client.StatusView
+
+	// where pushes and pulls report what they're doing; nil for stdout and
+	// the log.  watch sends it to the status view.
+	out io.Writer
+
+	// what cancels API requests, if not Context
+	requests context.Context
+
+	// watch's pushes and pulls; nil outside watch
+	pushes *syncQueue
+	pulls  *syncQueue
+
+	// what to run on sync events, from config.json
+	hooks   *client.Hooks
+	command string
+}
+
+type watchOptions struct {
+	dashboard bool
+	direction string
+	dirs      []string
 }
 
+// which way watch syncs
+const (
+	syncUp   = "up"
+	syncDown = "down"
+	syncBoth = "both"
+)
+
+// how long to wait for the user to sign in again after a 401
+const reauthTimeout = 5 * time.Minute
+
+// held while refreshing the token or writing auth.json, as each deck watch
+// keeps in sync may need to
+var tokenMutex sync.Mutex
+
+// held while the user signs in again after a 401, which can take minutes.
+// Requests that get a 401 meanwhile wait, then use the new token, rather
+// than opening another browser window.  It's separate from tokenMutex, so
+// the decks that aren't waiting on a sign-in keep going.
+var signInMutex sync.Mutex
+
 func main() {
-	c := &Client{ClientID: client.NewUUID()}
+	c := &Client{ClientID: client.NewUUID(), Context: context.Background()}
+
+	// global options come before the command, e.g. 'ultradeck --api-url=... push'
+	var overrides client.Endpoints
+	flag.StringVar(&overrides.APIURL, "api-url", "", "use a different ultradeck API")
+	flag.StringVar(&overrides.FrontendURL, "app-url", "", "use a different ultradeck web app")
+	flag.StringVar(&overrides.WebsocketURL, "ws-url", "", "use a different ultradeck websocket server")
+	flag.StringVar(&overrides.CABundle, "ca-bundle", "", "PEM file of extra certificate authorities to trust")
+	flag.StringVar(&overrides.Proxy, "proxy", "", "proxy to connect through")
+	flag.BoolVar(&overrides.AllowInsecure, "allow-insecure", false, "allow http:// and ws:// connections")
+	flag.Usage = c.printHelpScreen
+	flag.Parse()
+
+	endpoints, err := client.LoadEndpoints(overrides)
+	if err != nil {
+		c.exitWithError(err)
+	}
+	client.SetEndpoints(endpoints)
+
+	c.hooks, err = client.LoadHooks()
+	if err != nil {
+		c.exitWithError(err)
+	}
 
-	if len(os.Args) == 1 {
+	args := flag.Args()
+	if len(args) == 0 {
 		c.printHelpScreen()
 		os.Exit(0)
 	}
+	c.command = args[0]
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "auth":
+		c.Context = handleInterrupts()
 		c.doAuth()
 
 	// creates a new directory wioth a deck.md in it
@@ -51,104 +169,251 @@ func main() {
 	// ultradeck will check timestamp, and reject if timestamp on server is newer
 	// can be forced with -f
 	case "push":
-		c.authorizedCommand(c.push)
+		flags := flag.NewFlagSet("push", flag.ExitOnError)
+		force := flags.Bool("force", false, "push changes made offline even if the same slides changed on ultradeck.co")
+		flags.Parse(args[1:])
+
+		c.authorizedCommand(func(resp *client.AuthCheckResponse) error { return c.pushOrSave(resp, *force) })
 
 	// pull deck (and related assets) from ultradeck.co
 	// client will check timestamps and reject if client timestamp is newer
 	// must be done PER FILE
-	// can be forced with -f
+	// can be forced with --force
 	case "pull":
-		c.authorizedCommand(c.pull)
+		flags := flag.NewFlagSet("pull", flag.ExitOnError)
+		force := flags.Bool("force", false, "take the deck from ultradeck.co, throwing away local changes that haven't been pushed")
+		flags.Parse(args[1:])
+
+		c.authorizedCommand(func(resp *client.AuthCheckResponse) error { return c.pullOrForce(resp, *force) })
 
 	// watch a directory and auto-make changes on ultradeck's server
 	// uses websocket connection and other cool shit to pull this off
 	case "watch":
-		c.authorizedCommand(c.watch)
+		opts := &watchOptions{}
+		flags := flag.NewFlagSet("watch", flag.ExitOnError)
+		flags.BoolVar(&opts.dashboard, "dashboard", false, "show a status dashboard instead of log lines")
+		flags.StringVar(&opts.direction, "direction", syncBoth, "up to only push, down to only pull, or both")
+		flags.Parse(args[1:])
+		opts.dirs = flags.Args()
+
+		switch opts.direction {
+		case syncUp, syncDown, syncBoth:
+		default:
+			c.exitWithError(fmt.Errorf("--direction must be %s, %s or %s, not %q.", syncUp, syncDown, syncBoth, opts.direction))
+		}
+
+		c.Context = handleInterrupts()
+		c.authorizedCommand(func(resp *client.AuthCheckResponse) error { return c.watch(resp, opts) })
 
 	// upgrade to paid
 	case "upgrade":
-		c.authorizedCommand(c.upgradeToPaid)
+		flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+		printURL := flags.Bool("print-url", false, "print the link instead of opening a browser")
+		flags.Parse(args[1:])
+
+		c.authorizedCommand(func(resp *client.AuthCheckResponse) error { return c.upgradeToPaid(resp, *printURL) })
 
 	// internal for testing
 	case "check":
+		authCache := &client.AuthCheckCache{}
+		authCache.Remove()
 		c.authorizedCommand(c.checkAuth)
 
+	// show account details, usage and where the token came from
+	case "whoami":
+		flags := flag.NewFlagSet("whoami", flag.ExitOnError)
+		asJSON := flags.Bool("json", false, "print account details as JSON")
+		flags.Parse(args[1:])
+
+		authCache := &client.AuthCheckCache{}
+		authCache.Remove()
+		c.authorizedCommand(func(resp *client.AuthCheckResponse) error { return c.whoami(resp, *asJSON) })
+
 	// import a slide deck from ultradeck.co
 	case "import":
 		c.authorizedCommand(c.importDeck)
 	case "present":
-		c.openScreen("present")
+		c.localCommand(func(resp *client.AuthCheckResponse) error { return c.openScreen(resp, "present") })
 	case "edit":
-		c.openScreen("edit")
+		c.localCommand(func(resp *client.AuthCheckResponse) error { return c.openScreen(resp, "edit") })
 	}
 }
 
+// returns a context that's cancelled on the first Ctrl-C (or SIGTERM), so
+// the command can finish up.  A second Ctrl-C exits straight away.
+func handleInterrupts() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	interrupts := make(chan os.Signal, 2)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		cancel()
+		<-interrupts
+		os.Exit(exitInterrupted)
+	}()
+	return ctx
+}
+
 func (c *Client) doAuth() {
+	authJson, err := c.authenticate(c.Context)
+	if err != nil {
+		c.exitWithError(err)
+	}
+	if authJson == nil {
+		c.exitWithError(errors.New("The connection to ultradeck.co closed before you were authenticated.\nPlease run 'ultradeck auth' again."))
+	}
+	fmt.Println("You are now authenticated!")
+}
+
+// sends the user to ultradeck's login page, and waits for the result to come
+// back over the websocket, or until ctx is done.  Returns nil if the
+// connection closed before then.
+func (c *Client) authenticate(ctx context.Context) (*client.AuthJson, error) {
 	channel := client.NewUUID()
 
 	client.DebugMsg(fmt.Sprintf("Using channel: %s\n", channel))
 
-	c.Conn = client.NewWebsocketConnection(channel)
+	conn, err := client.NewWebsocketConnection(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
 
 	url := fmt.Sprintf("%s/beta-login?intermediate_token=%s", c.frontendURL(), channel)
 	open.Start(url)
 
-	c.Conn.RegisterListener()
+	conn.RegisterListener()
+
+	var authJson *client.AuthJson
+	var authErr error
+	handleAuth := func(req *client.Request) error {
+		authJson, authErr = c.processAuthResponse(conn, req)
+		return nil
+	}
+	conn.Handle(client.AuthCompleteMessage, handleAuth)
+	// older servers send the auth response without a kind
+	conn.HandleDefault(handleAuth)
+	conn.Handle(client.ErrorMessage, c.handleRemoteError)
 
 	requestChan := make(chan *client.Request)
-	go c.Conn.Listen(requestChan)
+	go conn.Listen(requestChan)
 
 	for {
 		select {
-		case <-c.Conn.Interrupt:
+		case <-ctx.Done():
 			client.DebugMsg("Interrupt")
-			os.Exit(0)
-			break
-		case <-c.Conn.Done:
-			fmt.Println("You are now authenticated!")
-			os.Exit(0)
-			break
+			conn.CloseConnection()
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, errSignInTimedOut
+			}
+			return nil, errInterrupted
+		case <-conn.Done:
+			return authJson, authErr
 		case msg := <-requestChan:
-			c.processAuthResponse(msg)
+			if err := conn.Dispatch(msg); err != nil {
+				log.Println(err)
+			}
 		}
 	}
 }
 
-func (c *Client) checkAuth(resp *client.AuthCheckResponse) {
+func (c *Client) checkAuth(resp *client.AuthCheckResponse) error {
 	fmt.Printf("\nWelcome, %s! You're signed in.\n", resp.Name)
+	return nil
 }
 
-func (c *Client) upgradeToPaid(resp *client.AuthCheckResponse) {
-	url := fmt.Sprintf("%s/auth", c.backendURL())
-	req, _ := http.NewRequest("GET", url, nil)
-	q := req.URL.Query()
-	q.Add("username", resp.Username)
-	q.Add("name", resp.Name)
-	q.Add("token", resp.Token)
-	q.Add("image_url", resp.ImageUrl)
-	q.Add("email", resp.Email)
-	q.Add("subscription_name", resp.SubscriptionName)
-	q.Add("redirect", "/account")
-	req.URL.RawQuery = q.Encode()
-
-	fmt.Printf("\nSending you to the pricing page...")
-	open.Start(req.URL.String())
+type whoamiOutput struct {
+	Username        string             `json:"username"`
+	Name            string             `json:"name"`
+	Email           string             `json:"email"`
+	Subscription    string             `json:"subscription"`
+	Profile         string             `json:"profile"`
+	TokenSource     string             `json:"token_source"`
+	TokenAgeSeconds *int64             `json:"token_age_seconds"`
+	Limits          *client.PlanLimits `json:"limits"`
 }
 
-type Deck struct {
-	Title string `json:"title"`
+func (c *Client) whoami(resp *client.AuthCheckResponse, asJSON bool) error {
+	authConfig := &client.AuthConfig{}
+	output := &whoamiOutput{
+		Username:     resp.Username,
+		Name:         resp.Name,
+		Email:        resp.Email,
+		Subscription: resp.SubscriptionName,
+		Profile:      client.Profile(),
+		TokenSource:  authConfig.TokenSource(),
+		Limits:       resp.Limits,
+	}
+	age, hasAge := authConfig.TokenAge()
+	if hasAge {
+		seconds := int64(age.Seconds())
+		output.TokenAgeSeconds = &seconds
+	}
+
+	if asJSON {
+		j, _ := json.MarshalIndent(output, "", "  ")
+		fmt.Println(string(j))
+		return nil
+	}
+
+	tokenSource := output.TokenSource
+	if tokenSource == "file" {
+		tokenSource = fmt.Sprintf("file (%s)", authConfig.ConfigFileLocation())
+	} else {
+		tokenSource = fmt.Sprintf("env (%s)", client.TokenEnvVar)
+	}
+
+	tokenAge := "unknown"
+	if hasAge {
+		tokenAge = formatAge(age)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Username:\t%s\n", output.Username)
+	fmt.Fprintf(w, "Name:\t%s\n", output.Name)
+	fmt.Fprintf(w, "Email:\t%s\n", output.Email)
+	fmt.Fprintf(w, "Subscription:\t%s\n", output.Subscription)
+	fmt.Fprintf(w, "Profile:\t%s\n", output.Profile)
+	fmt.Fprintf(w, "Token source:\t%s\n", tokenSource)
+	fmt.Fprintf(w, "Token age:\t%s\n", tokenAge)
+	if output.Limits != nil {
+		fmt.Fprintf(w, "Private decks:\t%s\n", formatUsage(int64(output.Limits.PrivateDecks), int64(output.Limits.PrivateDecksLimit), formatCount))
+		fmt.Fprintf(w, "Asset storage:\t%s\n", formatUsage(output.Limits.AssetStorageUsed, output.Limits.AssetStorageLimit, formatBytes))
+	}
+	return w.Flush()
 }
 
-type CreateDeck struct {
-	Deck *client.DeckConfig `json:"deck"`
+func (c *Client) upgradeToPaid(resp *client.AuthCheckResponse, printURL bool) error {
+	apiClient := c.apiClient(resp.Token)
+	loginCode, err := apiClient.CreateLoginCode()
+	if err != nil {
+		return err
+	}
+
+	// the subscription is likely to change, so check it again next time
+	authCache := &client.AuthCheckCache{}
+	authCache.Remove()
+
+	q := url.Values{}
+	q.Add("code", loginCode.Code)
+	q.Add("redirect", "/account")
+	link := fmt.Sprintf("%s/auth?%s", c.backendURL(), q.Encode())
+
+	if printURL {
+		fmt.Println("\nOpen this link to upgrade your account.  It can only be used once, and expires shortly:")
+		fmt.Println(link)
+		return nil
+	}
+
+	fmt.Printf("\nSending you to the pricing page...")
+	return open.Start(link)
 }
 
-func (c *Client) create(resp *client.AuthCheckResponse) {
+func (c *Client) create(resp *client.AuthCheckResponse) error {
 	prompt := promptui.Prompt{Label: " What is the name of your deck?", Validate: c.validateInput}
 	name, err := prompt.Run()
 	if err != nil {
-		fmt.Println("The deck needs a name!")
-		os.Exit(1)
+		return errors.New("The deck needs a name!")
 	}
 
 	prompt2 := promptui.Prompt{Label: "Description"}
@@ -156,22 +421,19 @@ func (c *Client) create(resp *client.AuthCheckResponse) {
 
 	deckConfigManager := &client.DeckConfigManager{}
 	deck := deckConfigManager.NewDeck(name, description)
-	httpClient := client.NewHttpClient(resp.Token)
+	apiClient := c.apiClient(resp.Token)
 
-	createDeck := &CreateDeck{Deck: deck}
-	j, _ := json.Marshal(&createDeck)
-	jsonData := httpClient.PostRequest("api/v1/decks", j)
+	serverDeckConfig, err := apiClient.CreateDeck(deck)
+	if err != nil {
+		return err
+	}
 
-	if httpClient.Response.StatusCode == 200 {
-		deckConfigManager.WriteJSON(jsonData)
+	deckConfigManager.DeckConfig = serverDeckConfig
+	deckConfigManager.WriteConfig()
 
-		fmt.Println("Creating deck.md")
-		deckConfigManager.WriteMarkdownFile("deck.md")
-	} else {
-		fmt.Println("Something went wrong with the request:")
-		dataString := string(jsonData)
-		fmt.Println(dataString)
-	}
+	fmt.Println("Creating deck.md")
+	deckConfigManager.WriteMarkdownFile("deck.md")
+	return nil
 }
 
 func (c *Client) validateInput(input string) error {
@@ -181,190 +443,1344 @@ func (c *Client) validateInput(input string) error {
 	return nil
 }
 
-func (c *Client) pull(resp *client.AuthCheckResponse) {
-	deckConfigManager := &client.DeckConfigManager{}
-	deckConfigManager.ReadConfig()
+func (c *Client) pull(resp *client.AuthCheckResponse) error {
+	return c.pullOrForce(resp, false)
+}
 
-	if !deckConfigManager.FileExists() {
-		fmt.Println("Could not find deck config!")
-		fmt.Println("Did you run 'ultradeck create' or 'ultradeck import' yet?")
+// pulls.  force takes the whole deck from ultradeck.co, even if it looks
+// older than ours, and throws away changes in the journal.
+func (c *Client) pullOrForce(resp *client.AuthCheckResponse, force bool) error {
+	return c.lockDeck(func() error { return c.reportSyncError(c.pullDeck(resp, force)) })
+}
+
+// where pushes and pulls print what they're doing
+func (c *Client) stdout() io.Writer {
+	if c.out != nil {
+		return c.out
+	}
+	return os.Stdout
+}
+
+func (c *Client) logln(v ...interface{}) {
+	if c.out != nil {
+		fmt.Fprintln(c.out, v...)
 		return
 	}
+	log.Println(v...)
+}
+
+// the deck's files, with pulls' writes recorded as our own
+func (c *Client) deckConfigManager() *client.DeckConfigManager {
+	deckConfigManager := client.NewDeckConfigManagerIn(c.dir)
+	deckConfigManager.Writes = &c.ownWrites
+	return deckConfigManager
+}
 
-	httpClient := client.NewHttpClient(resp.Token)
+func (c *Client) assetManager() *client.AssetManager {
+	return &client.AssetManager{Dir: c.dir, Out: c.out, Writes: &c.ownWrites, Context: c.requestContext()}
+}
+
+// an API client whose requests stop when we do
+func (c *Client) apiClient(token string) *client.ApiClient {
+	apiClient := client.NewApiClient(token)
+	apiClient.Context = c.requestContext()
+	return apiClient
+}
 
-	url := fmt.Sprintf("api/v1/decks/%s?username=%s", deckConfigManager.GetDeckID(), resp.Username)
-	jsonData := httpClient.GetRequest(url)
+func (c *Client) requestContext() context.Context {
+	if c.requests != nil {
+		return c.requests
+	}
+	return c.Context
+}
 
-	if httpClient.Response.StatusCode == 200 {
+// runs f holding the deck's lock, as watch pushes and pulls in the
+// background
+func (c *Client) lockDeck(f func() error) error {
+	c.deckMutex.Lock()
+	defer c.deckMutex.Unlock()
+	return f()
+}
 
-		var serverDeckConfig *client.DeckConfig
-		_ = json.Unmarshal(jsonData, &serverDeckConfig)
+func (c *Client) pullDeck(resp *client.AuthCheckResponse, force bool) error {
+	deckConfigManager := c.deckConfigManager()
+	if !deckConfigManager.FileExists() {
+		return errNoDeckConfig
+	}
 
-		// date on server must be equal to or greater than date on client
-		if c.dateCompare(serverDeckConfig.UpdatedAt, deckConfigManager.DeckConfig.UpdatedAt) >= 0 {
-			fmt.Println("Pulling changes from ultradeck.co...")
-			deckConfigManager.WriteJSON(jsonData)
-			deckConfigManager.WriteMarkdownFile("deck.md")
+	// .ud.json already has the journal's changes, so pulling now would
+	// throw them away
+	journal, err := client.ReadJournal(c.dir)
+	if err != nil {
+		return err
+	}
+	if !journal.Empty() && !force {
+		return errJournalPending
+	}
 
-			// pull remote assets as well
-			fmt.Println("Syncing assets...")
-			assetManager := client.AssetManager{}
-			assetManager.PullRemoteAssets(serverDeckConfig)
-			fmt.Println("Done!")
-		} else {
-			fmt.Println("It looks like you might have local changes that are not on the server!")
-			fmt.Println("Did you make changes to your deck elsewhere, or on ultradeck.co?")
-			fmt.Println("You can force by running 'ultradeck pull -f'.")
+	apiClient := c.apiClient(resp.Token)
+
+	// fetch just what changed since the last sync, if the server can
+	if deckConfigManager.DeckConfig.UpdatedAt != "" && !force {
+		changes, err := apiClient.GetDeckChanges(deckConfigManager.GetDeckID(), deckConfigManager.DeckConfig.UpdatedAt, resp.Username)
+		if err == nil {
+			return c.applyRemoteChanges(deckConfigManager, changes)
 		}
-	} else {
-		fmt.Println("Something went wrong with the request:")
-		fmt.Println(string(jsonData))
+		if !client.IsNotSupported(err) {
+			return err
+		}
+		client.DebugMsg("Server can't send deck changes; pulling the whole deck")
+	}
+
+	serverDeckConfig, err := apiClient.GetDeck(deckConfigManager.GetDeckID(), resp.Username)
+	if err != nil {
+		return err
+	}
+
+	// nothing changed, e.g. the message that made watch pull was about
+	// another deck
+	if serverDeckConfig.UpdatedAt != "" && serverDeckConfig.UpdatedAt == deckConfigManager.DeckConfig.UpdatedAt && !force {
+		client.DebugMsg("Already up to date")
+		return nil
+	}
+
+	// date on server must be equal to or greater than date on client
+	if c.dateCompare(serverDeckConfig.UpdatedAt, deckConfigManager.DeckConfig.UpdatedAt) >= 0 || force {
+		if !journal.Empty() {
+			fmt.Fprintf(c.stdout(), "Throwing away the changes in %s...\n", client.JournalFile)
+			journal.Entries = nil
+			if err := journal.Write(); err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintln(c.stdout(), "Pulling changes from ultradeck.co...")
+		deckConfigManager.DeckConfig = serverDeckConfig
+		deckConfigManager.WriteConfig()
+		deckConfigManager.WriteMarkdownFile("deck.md")
+
+		// pull remote assets as well
+		fmt.Fprintln(c.stdout(), "Syncing assets...")
+		assetManager := c.assetManager()
+		if err := assetManager.PullRemoteAssets(serverDeckConfig); err != nil {
+			return err
+		}
+		c.afterPull(serverDeckConfig)
+		fmt.Fprintln(c.stdout(), "Done!")
+		return nil
 	}
+
+	return errLocalChanges
 }
 
-func (c *Client) push(resp *client.AuthCheckResponse) {
-	deckConfigManager := &client.DeckConfigManager{}
-	deckConfigManager.ReadConfig()
+func (c *Client) applyRemoteChanges(deckConfigManager *client.DeckConfigManager, changes *client.DeckChanges) error {
+	// date on server must be equal to or greater than date on client
+	if c.dateCompare(changes.UpdatedAt, deckConfigManager.DeckConfig.UpdatedAt) < 0 {
+		return errLocalChanges
+	}
+	if len(changes.Ops) == 0 && changes.Assets == nil {
+		client.DebugMsg("Already up to date")
+		return nil
+	}
+
+	fmt.Fprintln(c.stdout(), "Pulling changes from ultradeck.co...")
+	deckConfigManager.DeckConfig.ApplyChanges(changes)
+	deckConfigManager.WriteConfig()
+	deckConfigManager.WriteMarkdownFile("deck.md")
+
+	if changes.Assets != nil {
+		fmt.Fprintln(c.stdout(), "Syncing assets...")
+		assetManager := c.assetManager()
+		if err := assetManager.PullRemoteAssets(deckConfigManager.DeckConfig); err != nil {
+			return err
+		}
+	}
+	c.afterPull(deckConfigManager.DeckConfig)
+	fmt.Fprintln(c.stdout(), "Done!")
+	return nil
+}
+
+// called once a pull has written deck.md and the assets
+func (c *Client) afterPull(deckConfig *client.DeckConfig) {
+	files := []string{"deck.md"}
+	for _, asset := range deckConfig.Assets {
+		files = append(files, asset.Filename)
+	}
+	if err := c.runHook(&client.HookEvent{Event: client.AfterPullEvent, Files: files}, deckConfig); err != nil {
+		c.logln(err)
+	}
+}
+
+// runs the hook for event, with the deck's details filled in
+func (c *Client) runHook(event *client.HookEvent, deckConfig *client.DeckConfig) error {
+	event.Command = c.command
+	event.Dir = c.dir
+	event.Output = c.out
+	if deckConfig != nil {
+		event.DeckUUID = deckConfig.UUID
+		event.DeckTitle = deckConfig.Title
+	}
+	return c.hooks.Run(event)
+}
+
+// runs the conflict or error hook if a push or pull failed, and passes err
+// on.  Being offline doesn't count, as watch keeps retrying.
+func (c *Client) reportSyncError(err error) error {
+	if _, offline := err.(*offlineError); err == nil || offline {
+		return err
+	}
+	if ctx := c.requestContext(); ctx != nil && ctx.Err() != nil {
+		// cut short by stopping, which isn't worth a hook
+		return err
+	}
 
+	event := &client.HookEvent{Event: client.ErrorEvent, Error: err.Error()}
+	if conflict, ok := err.(*client.JournalConflictError); ok {
+		event.Event = client.ConflictEvent
+		event.Slides = conflict.SlideUUIDs
+	} else if err == errLocalChanges || client.IsConflict(err) {
+		event.Event = client.ConflictEvent
+	}
+
+	deckConfigManager := c.deckConfigManager()
+	if hookErr := c.runHook(event, deckConfigManager.DeckConfig); hookErr != nil {
+		c.logln(hookErr)
+	}
+	return err
+}
+
+func (c *Client) push(resp *client.AuthCheckResponse) error {
+	return c.lockDeck(func() error { return c.reportSyncError(c.pushDeck(resp, false)) })
+}
+
+// pushes, and counts being offline as done: the changes are saved for next
+// time
+func (c *Client) pushOrSave(resp *client.AuthCheckResponse, force bool) error {
+	err := c.lockDeck(func() error { return c.reportSyncError(c.pushDeck(resp, force)) })
+	if offline, ok := err.(*offlineError); ok {
+		fmt.Fprintln(c.stdout(), offline)
+		return nil
+	}
+	return err
+}
+
+// pushes local changes.  If ultradeck.co can't be reached, slide changes
+// are written to the journal, and pushed next time.  force pushes them even
+// if the same slides changed on ultradeck.co in the meantime.
+func (c *Client) pushDeck(resp *client.AuthCheckResponse, force bool) error {
+	deckConfigManager := c.deckConfigManager()
 	if !deckConfigManager.FileExists() {
-		fmt.Println("Could not find deck config!")
-		fmt.Println("Did you run 'ultradeck create' yet?")
-		return
+		return errNoDeckConfig
+	}
+
+	// a failing before-push hook, e.g. a linter, stops the push
+	if err := c.runHook(&client.HookEvent{Event: client.BeforePushEvent}, deckConfigManager.DeckConfig); err != nil {
+		return err
+	}
+
+	journal, err := client.ReadJournal(c.dir)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("Pushing local changes to ultradeck.co...")
+	fmt.Fprintln(c.stdout(), "Pushing local changes to ultradeck.co...")
 
-	httpClient := client.NewHttpClient(resp.Token)
+	// remember what was last synced, to work out what changed.  It's a
+	// copy, as PushLocalAssets changes the deck's assets in place.
+	syncedAssets := append([]*client.Asset(nil), deckConfigManager.DeckConfig.Assets...)
 
 	// push local assets
-	assetManager := client.AssetManager{}
+	assetManager := c.assetManager()
+	assetManager.UploadProgress = c.reportUpload
+	if c.status == nil {
+		// watch pushes in the background, where there's nobody to ask
+		assetManager.ConfirmRemoval = c.confirmAssetRemoval
+	}
 
 	// TODO:  really not sure I like this type of decorator pattern
 	// can I make it cleaner?
-	deckConfigManager.DeckConfig = assetManager.PushLocalAssets(resp.Token, deckConfigManager.DeckConfig)
+	deckConfig, uploadErr := assetManager.PushLocalAssets(resp.Token, deckConfigManager.DeckConfig)
+	if uploadErr != nil && !client.IsNetworkError(uploadErr) {
+		return uploadErr
+	}
+	deckConfigManager.DeckConfig = deckConfig
 
-	url := fmt.Sprintf("api/v1/decks/%s?client_id=%s", deckConfigManager.GetDeckID(), c.ClientID)
-	jsonData := httpClient.PutRequest(url, deckConfigManager.PrepareJSONForUpload())
+	// .ud.json only lists assets once the deck on the server has them, so
+	// an asset change that doesn't make it is noticed again next time
+	localAssets := deckConfig.Assets
+	deckConfig.Assets = syncedAssets
 
-	if httpClient.Response.StatusCode == 200 {
-		deckConfigManager.WriteJSON(jsonData)
-		fmt.Println("Done!")
-	} else {
-		fmt.Println("Something went wrong with the request:")
-		fmt.Println(string(jsonData))
+	// .ud.json has the slides as last synced, plus the journal's changes,
+	// so these are the changes made since
+	ops := client.DiffSlides(deckConfig.Slides, deckConfigManager.ParseDeckMDFile())
+
+	if uploadErr != nil {
+		return c.saveForLater(deckConfigManager, journal, ops, uploadErr)
+	}
+
+	assetsChanged := !equalStrings(assetFilenames(syncedAssets), assetFilenames(localAssets))
+	if journal.Empty() && len(ops) == 0 && !assetsChanged {
+		fmt.Fprintln(c.stdout(), "Nothing to push.")
+		return nil
+	}
+
+	err = c.replayJournal(resp, deckConfigManager, journal, ops, localAssets, assetsChanged, force)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout(), "Done!")
+
+	if err := c.runHook(&client.HookEvent{Event: client.AfterPushEvent}, deckConfigManager.DeckConfig); err != nil {
+		c.logln(err)
+	}
+	return nil
+}
+
+// asks whether to take an asset that's on ultradeck.co, but not here any
+// more, out of the deck
+func (c *Client) confirmAssetRemoval(fileName string) bool {
+	fmt.Printf("The file %s exists on app.ultradeck.co, but not locally.  Do you want to delete it from your deck? (y/n) ", fileName)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return answer == "y\n"
+}
+
+// sends the changes left over in the journal in order, then ops.  Left over
+// changes are checked first, against anything changed on ultradeck.co since
+// they were made.  Asset changes still need the whole deck, as do servers
+// that don't take patches.  If ultradeck.co can't be reached, whatever
+// hasn't been sent is saved for later.  With force, or watch
+// --direction=up, a deck that changed on ultradeck.co meanwhile is replaced
+// with ours.
+func (c *Client) replayJournal(resp *client.AuthCheckResponse, deckConfigManager *client.DeckConfigManager, journal *client.Journal, ops []*client.SlideOp, localAssets []*client.Asset, assetsChanged bool, force bool) error {
+	apiClient := c.apiClient(resp.Token)
+	deckConfig := deckConfigManager.DeckConfig
+
+	// watch --direction=up never brings in remote changes; local wins
+	overwrite := c.direction == syncUp
+
+	failed := func(err error) error {
+		if client.IsNetworkError(err) {
+			return c.saveForLater(deckConfigManager, journal, ops, err)
+		}
+		return err
+	}
+
+	if !journal.Empty() && journal.BaseUpdatedAt != "" && !overwrite {
+		if err := c.mergeRemoteChanges(apiClient, resp, deckConfigManager, journal, ops, force); err != nil {
+			return failed(err)
+		}
 	}
+
+	for !assetsChanged && !journal.Empty() {
+		patch := &client.DeckPatch{BaseUpdatedAt: deckConfig.UpdatedAt, Ops: journal.Entries[0].Ops}
+		changes, err := apiClient.PatchDeck(deckConfig.UUID, patch, c.ClientID)
+		if client.IsNotSupported(err) {
+			client.DebugMsg("Server doesn't take patches; pushing the whole deck")
+			break
+		}
+		if client.IsConflict(err) && (overwrite || force) {
+			client.DebugMsg("The deck changed on ultradeck.co; replacing it with ours")
+			break
+		}
+		if err != nil {
+			return failed(err)
+		}
+
+		// the server echoes the ops it applied, with IDs for new slides
+		deckConfig.ApplyChanges(changes)
+		journal.Entries = journal.Entries[1:]
+		journal.BaseUpdatedAt = deckConfig.UpdatedAt
+		if err := journal.Write(); err != nil {
+			return err
+		}
+		deckConfigManager.WriteConfig()
+	}
+
+	if !assetsChanged && journal.Empty() && len(ops) > 0 {
+		patch := &client.DeckPatch{BaseUpdatedAt: deckConfig.UpdatedAt, Ops: ops}
+		changes, err := apiClient.PatchDeck(deckConfig.UUID, patch, c.ClientID)
+		switch {
+		case err == nil:
+			deckConfig.ApplyChanges(&client.DeckChanges{Ops: ops})
+			deckConfig.ApplyChanges(changes)
+			deckConfigManager.WriteConfig()
+			return nil
+		case client.IsNotSupported(err):
+			client.DebugMsg("Server doesn't take patches; pushing the whole deck")
+		case client.IsConflict(err) && (overwrite || force):
+			client.DebugMsg("The deck changed on ultradeck.co; replacing it with ours")
+		default:
+			return failed(err)
+		}
+	}
+	if journal.Empty() && len(ops) == 0 && !assetsChanged {
+		return nil
+	}
+
+	localDeckConfig := withSlideOps(deckConfig, ops)
+	localDeckConfig.Assets = localAssets
+	serverDeckConfig, err := apiClient.UpdateDeck(localDeckConfig, c.ClientID)
+	if err != nil {
+		return failed(err)
+	}
+
+	deckConfigManager.DeckConfig = serverDeckConfig
+	deckConfigManager.WriteConfig()
+	journal.Entries = nil
+	return journal.Write()
+}
+
+// saves ops to the journal, behind what's left of it, for when ultradeck.co
+// can be reached again.  .ud.json gets them too, so they aren't found again
+// next time.
+func (c *Client) saveForLater(deckConfigManager *client.DeckConfigManager, journal *client.Journal, ops []*client.SlideOp, err error) error {
+	if len(ops) > 0 {
+		journal.Append(deckConfigManager.DeckConfig.UpdatedAt, ops)
+		deckConfigManager.DeckConfig.ApplyChanges(&client.DeckChanges{Ops: ops})
+	}
+	if err := journal.Write(); err != nil {
+		return err
+	}
+	deckConfigManager.WriteConfig()
+	return &offlineError{Err: err}
+}
+
+// returns a copy of deckConfig with ops applied, leaving deckConfig alone
+func withSlideOps(deckConfig *client.DeckConfig, ops []*client.SlideOp) *client.DeckConfig {
+	updated := *deckConfig
+	updated.Slides = nil
+	for _, slide := range deckConfig.Slides {
+		copied := *slide
+		updated.Slides = append(updated.Slides, &copied)
+	}
+	updated.ApplyChanges(&client.DeckChanges{Ops: ops})
+	return &updated
 }
 
-func (c *Client) authorizedCommand(cmd func(resp *client.AuthCheckResponse)) {
+// brings in whatever changed on ultradeck.co since the journal was started,
+// except on the slides the journal or ops change, which is a conflict
+// unless forced.
+func (c *Client) mergeRemoteChanges(apiClient *client.ApiClient, resp *client.AuthCheckResponse, deckConfigManager *client.DeckConfigManager, journal *client.Journal, ops []*client.SlideOp, force bool) error {
+	deckConfig := deckConfigManager.DeckConfig
+
+	remote, err := apiClient.GetDeckChanges(deckConfig.UUID, journal.BaseUpdatedAt, resp.Username)
+	if client.IsNotSupported(err) {
+		// the server can't say what changed, only whether anything did
+		serverDeckConfig, err := apiClient.GetDeck(deckConfig.UUID, resp.Username)
+		if err != nil {
+			return err
+		}
+		if c.dateCompare(serverDeckConfig.UpdatedAt, journal.BaseUpdatedAt) > 0 && !force {
+			return &client.JournalConflictError{}
+		}
+		deckConfig.UpdatedAt = serverDeckConfig.UpdatedAt
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// ours are the journal's changes and the ones about to join it
+	ours := &client.Journal{Entries: append([]*client.JournalEntry{}, journal.Entries...)}
+	ours.Entries = append(ours.Entries, &client.JournalEntry{Ops: ops})
+
+	if conflicts := ours.Conflicts(remote); len(conflicts) > 0 && !force {
+		return &client.JournalConflictError{SlideUUIDs: conflicts}
+	}
+	if len(remote.Ops) == 0 && remote.Assets == nil {
+		return nil
+	}
+
+	fmt.Fprintln(c.stdout(), "Merging changes from ultradeck.co...")
+	deckConfig.ApplyChanges(ours.Without(remote))
+	deckConfigManager.WriteConfig()
+
+	// deck.md keeps the changes that haven't been sent yet
+	local := &client.DeckConfigManager{DeckConfig: withSlideOps(deckConfig, ops), Dir: c.dir, Writes: &c.ownWrites}
+	local.WriteMarkdownFile("deck.md")
+	if remote.Assets != nil {
+		assetManager := c.assetManager()
+		if err := assetManager.PullRemoteAssets(deckConfig); err != nil {
+			return err
+		}
+	}
+	c.afterPull(deckConfig)
+	return nil
+}
+
+// offlineError is what push returns when ultradeck.co couldn't be reached.
+// Nothing is lost: slide changes are in the journal, and new assets are
+// found again next time.
+type offlineError struct {
+	Err error
+}
+
+func (e *offlineError) Error() string {
+	return fmt.Sprintf("%s\nYour changes are saved in %s, and will be pushed once ultradeck.co can be reached.", e.Err, client.JournalFile)
+}
+
+func assetFilenames(assets []*client.Asset) []string {
+	var filenames []string
+	for _, asset := range assets {
+		filenames = append(filenames, asset.Filename)
+	}
+	return filenames
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) authorizedCommand(cmd func(resp *client.AuthCheckResponse) error) {
 	authConfig := &client.AuthConfig{}
-	if authConfig.AuthFileExists() {
-		token := authConfig.GetToken()
+	if !authConfig.HasToken() {
+		c.exitWithError(errNoAuthConfig)
+	}
+	token := c.currentToken()
 
+	// a recent auth check is trusted as-is.  If the token has been revoked
+	// since, the first API call will get a 401 and we re-authenticate then.
+	authCache := &client.AuthCheckCache{}
+	resp := authCache.Read(token)
+	if resp == nil {
 		authCheck := &client.AuthCheck{}
-		resp := authCheck.CheckAuth(token)
-		resp.Token = token
+		var err error
+		resp, err = authCheck.CheckAuth(token)
+		if err == nil && !resp.IsSignedIn {
+			// the token may have expired without auth.json saying when, in
+			// which case a refreshed one still works
+			if refreshed, ok := c.refreshToken(); ok {
+				token = refreshed
+				resp, err = authCheck.CheckAuth(token)
+			}
+		}
+		stale := authCache.ReadStale(token)
+		switch {
+		case client.IsNetworkError(err) && stale != nil:
+			// offline; the last check will do, so push and watch can keep
+			// working until we're back
+			client.DebugMsg(fmt.Sprintf("Could not check auth, using the last check: %s", err))
+			resp = stale
+		case err != nil:
+			c.exitWithError(err)
+		case !resp.IsSignedIn:
+			c.exitWithError(errSignedOut)
+		default:
+			authCache.Write(token, resp)
+		}
+	}
+	resp.Token = token
 
-		if resp.IsSignedIn {
-			cmd(resp)
-		} else {
-			fmt.Println("\nIt does not look like you're signed in anymore.")
-			fmt.Println("Please run 'ultradeck auth' to sign in again.")
+	client.UnauthorizedHandler = func(token string) (string, bool) {
+		return c.reauthenticate(resp, token)
+	}
+	if err := cmd(resp); err != nil {
+		c.exitWithError(err)
+	}
+}
+
+// runs a command that needs to know who the user is, but never talks to the
+// backend.  The user details come from auth.json, so this works offline.
+func (c *Client) localCommand(cmd func(resp *client.AuthCheckResponse) error) {
+	authConfig := &client.AuthConfig{}
+	authJson := authConfig.ReadConfig()
+	if authJson == nil {
+		c.exitWithError(errNoAuthConfig)
+	}
+
+	if err := cmd(authJson.ToAuthCheckResponse()); err != nil {
+		c.exitWithError(err)
+	}
+}
+
+// prints err and exits with a code that tells scripts what kind of failure
+// it was
+func (c *Client) exitWithError(err error) {
+	fmt.Println()
+	switch err.(type) {
+	case *client.NetworkError:
+		fmt.Println("Error contacting server:", err)
+		os.Exit(exitNetworkError)
+	case *client.ClientError:
+		fmt.Println("Something went wrong with the request:")
+		fmt.Println(err)
+		os.Exit(exitRequestError)
+	case *client.ServerError:
+		fmt.Println("Something went wrong with the request:")
+		fmt.Println(err)
+		os.Exit(exitServerError)
+	default:
+		fmt.Println(err)
+		if err == errInterrupted {
+			os.Exit(exitInterrupted)
 		}
-	} else {
-		fmt.Println("\nNo auth config file found!")
-		fmt.Println("Please run 'ultradeck auth' to log in.")
+		os.Exit(exitError)
 	}
 }
 
-func (c *Client) watch(resp *client.AuthCheckResponse) {
-	watcher, err := fsnotify.NewWatcher()
+// returns the saved token, refreshing it first if it's about to expire
+func (c *Client) currentToken() string {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+
+	authConfig := &client.AuthConfig{}
+	if authConfig.TokenSource() == "env" {
+		return authConfig.GetToken()
+	}
+
+	authJson := authConfig.ReadConfig()
+	if authJson == nil {
+		return ""
+	}
+
+	if authJson.ExpiresSoon() {
+		client.DebugMsg("Token expires soon, refreshing")
+		authConfig.AuthJson = authJson
+		if err := authConfig.Refresh(); err != nil {
+			client.DebugMsg(fmt.Sprintf("Could not refresh token: %s", err))
+		}
+	}
+	return authJson.Token
+}
+
+// exchanges the refresh token in auth.json for a new token.  ok is false if
+// there's no refresh token, or the token comes from the environment.
+func (c *Client) refreshToken() (token string, ok bool) {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+
+	authConfig := &client.AuthConfig{}
+	if authConfig.TokenSource() == "env" {
+		return "", false
+	}
+	if err := authConfig.Refresh(); err != nil {
+		client.DebugMsg(fmt.Sprintf("Could not refresh token: %s", err))
+		return "", false
+	}
+	return authConfig.AuthJson.Token, true
+}
+
+// called when the backend rejects token partway through a command.
+// Refreshes the token if we can, otherwise signs the user in again, and
+// hands back the new token so the request that failed can be retried.
+func (c *Client) reauthenticate(resp *client.AuthCheckResponse, token string) (string, bool) {
+	if newToken, ok, done := c.refreshRejectedToken(token); done {
+		if ok {
+			resp.Token = newToken
+		}
+		return newToken, ok
+	}
+
+	// only one sign-in at a time; whoever waited here uses its token
+	signInMutex.Lock()
+	defer signInMutex.Unlock()
+
+	authConfig := &client.AuthConfig{}
+	if authJson := authConfig.ReadConfig(); authJson != nil && authJson.Token != token {
+		resp.Token = authJson.Token
+		return resp.Token, true
+	}
+
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		fmt.Fprintln(c.stdout(), "\nYour ultradeck.co session has expired.  Run 'ultradeck auth' to sign in again.")
+		return "", false
+	}
+
+	fmt.Fprintln(c.stdout(), "\nYour ultradeck.co session has expired.  Opening your browser so you can sign in again...")
+	ctx, cancel := context.WithTimeout(c.Context, reauthTimeout)
+	defer cancel()
+	authJson, err := c.authenticate(ctx)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(c.stdout(), err)
+		return "", false
 	}
-	defer watcher.Close()
+	if authJson == nil {
+		return "", false
+	}
+
+	resp.Token = authJson.Token
+	return authJson.Token, true
+}
 
-	fmt.Println("Watching directory for changes...")
+// the quick ways to replace a rejected token: a token someone else already
+// got, or a refreshed one.  done is false if the user has to sign in again.
+func (c *Client) refreshRejectedToken(token string) (newToken string, ok bool, done bool) {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
 
-	done := make(chan bool)
-	requestChan := make(chan *client.Request)
+	authConfig := &client.AuthConfig{}
+	if authConfig.TokenSource() == "env" {
+		// a new token would be saved to auth.json, where the environment
+		// overrides it, and there may be nobody around to sign in anyway
+		client.DebugMsg(fmt.Sprintf("The token from %s was rejected", client.TokenEnvVar))
+		return "", false, true
+	}
 
-	c.Conn = client.NewWebsocketConnection(resp.UUID)
+	authCache := &client.AuthCheckCache{}
+	authCache.Remove()
+
+	authConfig.AuthJson = authConfig.ReadConfig()
+	if authConfig.AuthJson != nil && authConfig.AuthJson.Token != token {
+		// someone else already got a new one
+		return authConfig.AuthJson.Token, true, true
+	}
+
+	if err := authConfig.Refresh(); err != nil {
+		client.DebugMsg(fmt.Sprintf("Could not refresh token: %s", err))
+		return "", false, false
+	}
+	return authConfig.AuthJson.Token, true, true
+}
+
+// one deck watch keeps in sync.  Each deck has its own loop, and its own
+// Client, fed the messages about it by the loop that reads the websocket.
+type watchedDeck struct {
+	c        *Client
+	uuid     string
+	title    string
+	label    string
+	requests chan *client.Request
+	states   chan client.ConnectionState
+	handlers client.MessageHandlers
+}
+
+func (c *Client) watch(resp *client.AuthCheckResponse, opts *watchOptions) error {
+	dirs := opts.dirs
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	if opts.dashboard && len(dirs) > 1 {
+		return errors.New("--dashboard only works when watching a single deck.")
+	}
+
+	// cancelled on Ctrl-C, or if a deck can't be watched any more
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+
+	// set up to reconnect before dialing, so watch can start offline.  The
+	// decks share the connection, as the user's channel carries changes to
+	// all their decks.
+	c.Conn = &client.WebsocketConnection{ClientID: client.NewUUID(), Channel: resp.UUID, AutoReconnect: true}
+
+	decks, err := c.watchedDecks(ctx, dirs, opts.direction)
+	if err != nil {
+		return err
+	}
+	for _, deck := range decks {
+		deck.c.status = client.NewStatusView(deck.title, opts.dashboard)
+		deck.c.status.Label = deck.label
+		deck.c.out = deck.c.status
+	}
+	if len(decks) == 1 {
+		// what we print, e.g. when signing in again after a 401, goes
+		// where the deck's output does, which may be the dashboard
+		c.out = decks[0].c.status
+	}
+
+	// only hear about the decks we're watching, from servers that can
+	for _, deck := range decks {
+		c.Conn.DeckUUIDs = append(c.Conn.DeckUUIDs, deck.uuid)
+	}
+	if err := c.Conn.OpenConnection(ctx); err != nil {
+		return err
+	}
+	c.routeMessages(ctx, decks)
 	c.Conn.RegisterListener()
 	c.Conn.SetupPinger()
+
+	requestChan := make(chan *client.Request)
 	go c.Conn.Listen(requestChan)
 
-	// add a listener to listen for changes to .ud.json, to push to backend
-	go func() {
-		for {
-			select {
-			case event := <-watcher.Events:
-				if event.Name == "./.ud.json" {
-					continue
-				}
-				if event.Op == fsnotify.Write || event.Op == fsnotify.Create || event.Op == fsnotify.Remove {
-					c.push(resp)
+	// each deck pushes what's left when ctx is done, so the connection stays
+	// open until they all have
+	defer c.Conn.CloseConnection()
+
+	stopped := make(chan error, len(decks))
+	for _, deck := range decks {
+		go func(deck *watchedDeck) {
+			// each loop refreshes its own copy of the token
+			deckResp := *resp
+			stopped <- deck.c.watchDeck(&deckResp, deck)
+		}(deck)
+	}
+
+	var firstErr error
+	connDone := c.Conn.Done
+	for running := len(decks); running > 0; {
+		select {
+		case req := <-requestChan:
+			// a request came in from the backend, via the websocket channel.
+			if err := c.Conn.Dispatch(req); err != nil {
+				c.logln(err)
+			}
+
+		case state := <-c.Conn.StateChanges:
+			for _, deck := range decks {
+				select {
+				case deck.states <- state:
+				default:
+					client.DebugMsg("dropping connection state change for " + deck.label)
 				}
-			case req := <-requestChan:
-				// a request came in from the backend, via the websocket channel.
+			}
+
+		case err := <-stopped:
+			running--
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+
+		case <-connDone:
+			connDone = nil
+			if firstErr == nil && ctx.Err() == nil {
+				firstErr = &client.NetworkError{Err: errors.New("the websocket connection closed")}
+			}
+			cancel()
+		}
+	}
+	return firstErr
+}
+
+// sets up a Client for each deck directory, sharing our connection
+func (c *Client) watchedDecks(ctx context.Context, dirs []string, direction string) ([]*watchedDeck, error) {
+	var decks []*watchedDeck
+	seen := map[string]string{}
 
-				// ensure the client id is not ours.  if it is, ignore. if not, do an update.
-				client.DebugMsg("request ClientID = " + req.ClientID)
-				client.DebugMsg("request = " + req.Request)
-				client.DebugMsg("my ClientID = " + c.ClientID)
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		deckClient := &Client{Conn: c.Conn, ClientID: c.ClientID, Context: ctx, hooks: c.hooks, command: c.command, dir: abs, direction: direction}
+
+		deckConfig := client.NewDeckConfigManagerIn(abs).DeckConfig
+		if deckConfig == nil {
+			if len(dirs) == 1 {
+				return nil, errNoDeckConfig
+			}
+			return nil, fmt.Errorf("%s: %s", dir, errNoDeckConfig)
+		}
+		if other, ok := seen[deckConfig.UUID]; ok {
+			return nil, fmt.Errorf("%s and %s are the same deck.", other, dir)
+		}
+		seen[deckConfig.UUID] = dir
+
+		deck := &watchedDeck{
+			c:        deckClient,
+			uuid:     deckConfig.UUID,
+			title:    deckConfig.Title,
+			requests: make(chan *client.Request, 16),
+			states:   make(chan client.ConnectionState, 16),
+		}
+		if len(dirs) > 1 {
+			deck.label = dir
+		}
+		decks = append(decks, deck)
+	}
+	return decks, nil
+}
 
-				if req.ClientID != c.ClientID && req.Request != "pong" {
-					client.DebugMsg("No match, so initiating a pull")
-					c.pull(resp)
+// passes each message from the backend to the deck it's about.  Messages
+// that don't say which deck, e.g. from older servers, go to every deck.
+func (c *Client) routeMessages(ctx context.Context, decks []*watchedDeck) {
+	c.Conn.Handle(client.PongMessage, func(req *client.Request) error { return nil })
+	c.Conn.HandleDefault(func(req *client.Request) error {
+		uuid := req.DeckUUID()
+		for _, deck := range decks {
+			if uuid != "" && uuid != deck.uuid {
+				continue
+			}
+			select {
+			case deck.requests <- req:
+			case <-ctx.Done():
+			}
+		}
+		return nil
+	})
+}
+
+// keeps one deck in sync with ultradeck.co, until its context is done
+func (c *Client) watchDeck(resp *client.AuthCheckResponse, deck *watchedDeck) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	c.ignore = client.LoadIgnoreRulesOrDefault(c.dir, c.stdout())
+	c.registerWatchHandlers(&deck.handlers)
+
+	err = c.watchDirectory(watcher, c.dir)
+	if err != nil {
+		return err
+	}
+
+	slides := c.slideCount()
+	c.status.Update("", func(status *client.WatchStatus) {
+		status.Slides = slides
+		status.Direction = c.direction
+	})
+	c.status.Start()
+	defer c.status.Stop()
+	c.status.Update("Watching directory for changes...", nil)
+
+	// a watch session can outlive the token, so keep refreshing it.  If it
+	// can't be refreshed, the next push gets a 401 and we sign in again.
+	tokenTicker := time.NewTicker(time.Minute)
+	defer tokenTicker.Stop()
+
+	// while offline, keep trying to push what's in the journal
+	offline := false
+	retryTicker := time.NewTicker(offlineRetryInterval)
+	defer retryTicker.Stop()
+
+	pushes := newSyncQueue(func(resp *client.AuthCheckResponse) error {
+		c.status.Update("", func(status *client.WatchStatus) { status.Pushing = true })
+
+		err := c.push(resp)
+
+		slides := c.slideCount()
+		c.status.Update("", func(status *client.WatchStatus) {
+			status.Pushing = false
+			status.LastPush = time.Now()
+			status.LastPushErr = err
+			status.Slides = slides
+		})
+		return err
+	})
+	if c.direction == syncDown {
+		// changes still go through the queue, so there's one warning per
+		// save rather than one per file event
+		pushes.run = func(*client.AuthCheckResponse) error { return errNotPushing }
+	}
+	defer pushes.timer.Stop()
+	c.pushes = pushes
+
+	pulls := newSyncQueue(func(resp *client.AuthCheckResponse) error {
+		c.status.Update("", func(status *client.WatchStatus) { status.Pulling = true })
+
+		err := c.pull(resp)
+
+		slides := c.slideCount()
+		c.status.Update("", func(status *client.WatchStatus) {
+			status.Pulling = false
+			status.LastPull = time.Now()
+			status.LastPullErr = err
+			status.Slides = slides
+		})
+		return err
+	})
+	defer pulls.timer.Stop()
+	c.pulls = pulls
+
+	// changes made while watch wasn't running
+	if c.direction != syncDown && c.journalPending() {
+		pushes.changed()
+	}
+
+	for {
+		select {
+		case event := <-watcher.Events:
+			// watch new directories too, e.g. a new folder of images
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := c.watchDirectory(watcher, event.Name); err != nil {
+						c.status.Error(fmt.Errorf("could not watch %s: %s", c.relPath(event.Name), err))
+					}
 				}
+			}
+
+			// listen for changes to the deck, to push to backend
+			if c.isPushableEvent(event) {
+				pushes.changed()
+			}
 
-			case err := <-watcher.Errors:
-				log.Println("error:", err)
+		case <-pushes.timer.C:
+			pushes.start(resp)
 
-			case <-c.Conn.Interrupt:
-				fmt.Println("interrupt")
-				os.Exit(0)
+		case err := <-pushes.done:
+			if err == errNotPushing {
+				pushes.finished(nil)
+				c.status.Update("Your local changes won't be pushed, as watch only pulls (--direction=down).  The next change pulled from ultradeck.co overwrites them.", nil)
 				break
-			case <-c.Conn.Done:
-				fmt.Println("done")
-				os.Exit(0)
+			}
+			pushes.finished(err)
+			_, offline = err.(*offlineError)
+			if offline {
+				c.status.Update("Offline; changes saved in "+client.JournalFile+" will be pushed when ultradeck.co can be reached.", nil)
+			} else if err != nil {
+				c.status.Error(fmt.Errorf("push failed: %s", err))
+			}
+
+		case <-retryTicker.C:
+			if offline {
+				pushes.changed()
+			}
+
+		case <-pulls.timer.C:
+			pulls.start(resp)
+
+		case err := <-pulls.done:
+			// a failed pull isn't retried until the next change comes in
+			pulls.finished(nil)
+			if err != nil {
+				c.status.Error(fmt.Errorf("pull failed: %s", err))
+			}
+
+		case req := <-deck.requests:
+			if err := deck.handlers.Dispatch(req); err != nil {
+				c.status.Error(err)
+			}
+
+		case err := <-watcher.Errors:
+			c.status.Error(err)
+
+		case state := <-deck.states:
+			c.status.Update(state.String(), func(status *client.WatchStatus) { status.Connection = state })
+
+			// anything pushed while we were away was missed, so catch up.
+			// watchPull pushes the journal instead, if there is one.
+			if state == client.Connected && c.direction != syncUp {
+				c.watchPull()
+			}
+
+		case <-tokenTicker.C:
+			resp.Token = c.currentToken()
+
+		case <-c.Context.Done():
+			// what's left to push is printed below the dashboard
+			c.status.Stop()
+			c.status.Update("Stopping...", nil)
+			return c.stopWatching(watcher, resp)
+		}
+
+		c.status.Update("", func(status *client.WatchStatus) { status.Pending = pushes.dirty || offline })
+	}
+}
+
+// pulls in the background.  While there are changes in the journal,
+// pushes instead, which brings in the remote changes too.
+func (c *Client) watchPull() {
+	if c.direction != syncDown && c.journalPending() {
+		c.pushes.changed()
+		return
+	}
+	c.pulls.changed()
+}
+
+// the journal and .ud.json are replaced in one go, so these don't need the
+// deck's lock, and don't wait for a push or pull to finish
+
+func (c *Client) journalPending() bool {
+	journal, err := client.ReadJournal(c.dir)
+	return err == nil && !journal.Empty()
+}
+
+func (c *Client) slideCount() int {
+	deckConfigManager := c.deckConfigManager()
+	if deckConfigManager.DeckConfig == nil {
+		return 0
+	}
+	return len(deckConfigManager.DeckConfig.Slides)
+}
+
+// keeps the status's list of uploads in progress up to date
+func (c *Client) reportUpload(fileName string, finished bool) {
+	c.status.Update("", func(status *client.WatchStatus) {
+		if !finished {
+			status.Uploading = append(status.Uploading, fileName)
+			return
+		}
+		for i, uploading := range status.Uploading {
+			if uploading == fileName {
+				status.Uploading = append(status.Uploading[:i], status.Uploading[i+1:]...)
 				break
 			}
 		}
-	}()
+	})
+}
+
+// watches dir and every directory under it that isn't ignored
+func (c *Client) watchDirectory(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			// removed while we were walking
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if c.ignore.Ignored(c.relPath(path), true) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// path relative to the deck directory, which is what the ignore rules
+// expect
+func (c *Client) relPath(path string) string {
+	if rel, err := filepath.Rel(c.dir, path); err == nil {
+		return rel
+	}
+	return path
+}
 
-	err = watcher.Add(".")
+func (c *Client) isPushableEvent(event fsnotify.Event) bool {
+	name := c.relPath(event.Name)
+	switch filepath.Base(name) {
+	case ".ud.json", client.JournalFile:
+		return false
+	}
+	if name == client.IgnoreFile {
+		c.ignore = client.LoadIgnoreRulesOrDefault(c.dir, c.stdout())
+		return false
+	}
+
+	info, err := os.Stat(event.Name)
+	isDir := err == nil && info.IsDir()
+	if c.ignore.Ignored(name, isDir) {
+		return false
+	}
+	// pulling writes deck.md and assets, which isn't a change to push back
+	if event.Op&fsnotify.Remove == 0 && c.ownWrites.IsOwnWrite(event.Name) {
+		client.DebugMsg("Ignoring our own write to " + name)
+		return false
+	}
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+		return false
+	}
+
+	// only deck.md and images are pushed, so other files, e.g. notes kept
+	// next to the deck, don't cause a push
+	switch {
+	case name == "deck.md", client.IsAsset(name):
+		return true
+	case isDir:
+		// a new folder, maybe of images
+		return event.Op&fsnotify.Create != 0
+	case err != nil:
+		// gone, so there's no telling whether it was a folder of images,
+		// unless it looks like a file
+		return event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && filepath.Ext(name) == ""
+	}
+	return false
+}
+
+// a single save usually fires several events, so wait this long for them
+// to settle before pushing
+const pushDebounce = 300 * time.Millisecond
+
+// how often watch tries to push the journal while offline
+const offlineRetryInterval = 30 * time.Second
+
+// syncQueue coalesces changes into pushes or pulls, which run off the event
+// loop one at a time, so the loop keeps handling file events and messages
+// meanwhile.  It isn't safe for concurrent use; the watch loop drives it,
+// calling start when timer fires and finished with whatever arrives on done.
+type syncQueue struct {
+	run   func(resp *client.AuthCheckResponse) error
+	timer *time.Timer
+	done  chan error
+
+	dirty   bool // a change hasn't been synced yet
+	running bool
+}
+
+func newSyncQueue(run func(resp *client.AuthCheckResponse) error) *syncQueue {
+	q := &syncQueue{run: run, timer: time.NewTimer(pushDebounce), done: make(chan error, 1)}
+	q.timer.Stop()
+	return q
+}
+
+func (q *syncQueue) changed() {
+	q.dirty = true
+	q.timer.Reset(pushDebounce)
+}
+
+func (q *syncQueue) start(resp *client.AuthCheckResponse) {
+	// a run already in flight picks up the change when it finishes
+	if !q.dirty || q.running {
+		return
+	}
+
+	q.dirty = false
+	q.running = true
+	// the loop keeps refreshing resp.Token, so the run gets its own copy
+	runResp := *resp
+	go func() { q.done <- q.run(&runResp) }()
+}
+
+func (q *syncQueue) finished(err error) {
+	q.running = false
 	if err != nil {
-		log.Fatal(err)
+		// try again with the next change, or when watch stops
+		q.dirty = true
+		return
+	}
+	if q.dirty {
+		q.timer.Reset(pushDebounce)
+	}
+}
+
+// waits for the run in flight, if there is one
+func (q *syncQueue) wait() error {
+	q.timer.Stop()
+	if !q.running {
+		return nil
+	}
+	err := <-q.done
+	q.finished(err)
+	return err
+}
+
+// waits for the run in flight, then syncs anything still unsynced,
+// reporting on it to out
+func (q *syncQueue) flush(resp *client.AuthCheckResponse, out io.Writer) error {
+	if err := q.wait(); err != nil {
+		fmt.Fprintln(out, "push failed:", err)
+	}
+	if !q.dirty {
+		return nil
+	}
+
+	fmt.Fprintln(out, "Pushing your last changes...")
+	q.dirty = false
+	if err := q.run(resp); err != nil {
+		q.dirty = true
+		return err
+	}
+	return nil
+}
+
+// lets the pull in flight finish, then pushes any change that hasn't made
+// it to ultradeck.co yet, including ones the watcher noticed but we
+// haven't got to.
+func (c *Client) stopWatching(watcher *fsnotify.Watcher, resp *client.AuthCheckResponse) error {
+	if err := c.pulls.wait(); err != nil {
+		c.logln("pull failed:", err)
+	}
+
+	// the push or pull in flight was cut short by Context, but the last
+	// push isn't.  Another Ctrl-C exits instead.
+	c.requests = context.Background()
+	if c.direction == syncDown {
+		return nil
+	}
+
+	for drained := false; !drained; {
+		select {
+		case event := <-watcher.Events:
+			if c.isPushableEvent(event) {
+				c.pushes.dirty = true
+			}
+		default:
+			drained = true
+		}
+	}
+
+	err := c.pushes.flush(resp, c.stdout())
+	if offline, ok := err.(*offlineError); ok {
+		fmt.Fprintln(c.stdout(), offline)
+		return nil
+	}
+	return err
+}
+
+// what watch does with each kind of message about the deck
+func (c *Client) registerWatchHandlers(handlers *client.MessageHandlers) {
+	pullRemoteChange := func(req *client.Request) error {
+		// ensure the client id is not ours.  if it is, ignore. if not, do an update.
+		client.DebugMsg("request ClientID = " + req.ClientID)
+		client.DebugMsg("request = " + string(req.Kind))
+		client.DebugMsg("my ClientID = " + c.ClientID)
+
+		if req.ClientID == c.ClientID {
+			return nil
+		}
+		if c.direction == syncUp {
+			c.status.Update("The deck changed on ultradeck.co, but watch only pushes (--direction=up), so it isn't pulled.  Your next push overwrites the change.", nil)
+			return nil
+		}
+		client.DebugMsg("No match, so initiating a pull")
+		c.watchPull()
+		return nil
 	}
-	<-done
+
+	handlers.Handle(client.DeckUpdatedMessage, pullRemoteChange)
+	handlers.Handle(client.SlideUpdatedMessage, pullRemoteChange)
+	// older servers don't say what changed, so treat anything else as a change
+	handlers.HandleDefault(pullRemoteChange)
+
+	handlers.Handle(client.ErrorMessage, c.handleRemoteError)
+	handlers.Handle(client.PresenceMessage, c.handlePresence)
 }
 
-func (c *Client) importDeck(resp *client.AuthCheckResponse) {
-	httpClient := client.NewHttpClient(resp.Token)
-	url := fmt.Sprintf("api/v1/decks?username=%s", resp.Username)
-	jsonData := httpClient.GetRequest(url)
+func (c *Client) handleRemoteError(req *client.Request) error {
+	remoteErr := &client.RemoteError{}
+	if err := req.DecodeData(remoteErr); err != nil {
+		return err
+	}
+	return fmt.Errorf("ultradeck.co reported an error: %s", remoteErr)
+}
 
-	type GetResponse struct {
-		Decks []*client.DeckConfig
+func (c *Client) handlePresence(req *client.Request) error {
+	presence := &client.Presence{}
+	if err := req.DecodeData(presence); err != nil {
+		return err
 	}
 
-	var serverDeckConfig GetResponse
-	_ = json.Unmarshal(jsonData, &serverDeckConfig)
+	var names []string
+	for _, user := range presence.Users {
+		if user.ClientID == c.ClientID {
+			continue
+		}
+		if user.Name != "" {
+			names = append(names, user.Name)
+		} else {
+			names = append(names, user.Username)
+		}
+	}
+
+	message := "Nobody else is editing this deck."
+	if len(names) > 0 {
+		message = fmt.Sprintf("Also editing this deck: %s", strings.Join(names, ", "))
+	}
+	c.status.Update(message, func(status *client.WatchStatus) { status.Collaborators = names })
+	return nil
+}
+
+func (c *Client) importDeck(resp *client.AuthCheckResponse) error {
+	apiClient := c.apiClient(resp.Token)
+	decks, err := apiClient.ListDecks(resp.Username)
+	if err != nil {
+		return err
+	}
 
 	var titles []string
-	for _, deck := range serverDeckConfig.Decks {
+	for _, deck := range decks {
 		titles = append(titles, deck.Title)
 	}
 
 	prompt3 := promptui.Select{Label: "Which deck to import?", Items: titles}
 	_, deckTitleToImport, err := prompt3.Run()
 	if err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	var selectedDeck *client.DeckConfig
-	for _, deck := range serverDeckConfig.Decks {
+	for _, deck := range decks {
 		if deck.Title == deckTitleToImport {
 			selectedDeck = deck
 		}
@@ -380,47 +1796,55 @@ func (c *Client) importDeck(resp *client.AuthCheckResponse) {
 	// pull remote assets as well
 	fmt.Println("Syncing assets...")
 	assetManager := client.AssetManager{}
-	assetManager.PullRemoteAssets(selectedDeck)
+	if err := assetManager.PullRemoteAssets(selectedDeck); err != nil {
+		return err
+	}
 	fmt.Println("Done!")
+	return nil
 }
 
-func (c *Client) openScreen(screenName string) {
+func (c *Client) openScreen(resp *client.AuthCheckResponse, screenName string) error {
 	deckConfigManager := client.NewDeckConfigManager()
-	deckConfigManager.ReadConfig()
 	if !deckConfigManager.FileExists() {
-		fmt.Println("Could not find deck config!")
-		fmt.Println("Did you run 'ultradeck create' or 'ultradeck import' yet?")
-	}
-	authConfig := &client.AuthConfig{}
-	if authConfig.AuthFileExists() {
-		userData := authConfig.ReadConfig()
-		shortUUID := deckConfigManager.GetDeckShortUUID()
-		fmt.Println("shortUUID is ", shortUUID)
-		slug := deckConfigManager.DeckConfig.Slug
-		fmt.Printf("Opening browser to %s screen...\n", screenName)
-		url := fmt.Sprintf("%s/users/%s/decks/%s/%s/%s", c.frontendURL(), userData.Username, shortUUID, slug, screenName)
-		open.Start(url)
+		return errNoDeckConfig
 	}
+	shortUUID := deckConfigManager.GetDeckShortUUID()
+	client.DebugMsg("shortUUID is " + shortUUID)
+	slug := deckConfigManager.DeckConfig.Slug
+	fmt.Printf("Opening browser to %s screen...\n", screenName)
+	url := fmt.Sprintf("%s/users/%s/decks/%s/%s/%s", c.frontendURL(), resp.Username, shortUUID, slug, screenName)
+	return open.Start(url)
 }
 
 func (c *Client) printHelpScreen() {
 	fmt.Printf("UltraDeck v%s\n", Version)
 	fmt.Println("The ultradeck command-line utility allows you to create and manipulate decks straight from your local machine.")
-	fmt.Println("When a directory is under ultradeck control, there will be a .ud.json file, a deck.md file, and any picture assets that are part of the deck.\n")
+	fmt.Println("When a directory is under ultradeck control, there will be a .ud.json file, a deck.md file, and any picture assets that are part of the deck.")
+	fmt.Println()
 
 	fmt.Println("Command List for decks:")
 	fmt.Println("\tcreate\t\t Create a new deck")
 	fmt.Println("\timport\t\t Import a deck from ultradeck.co to the local directory")
-	fmt.Println("\tpush\t\t Push local changes to ultradeck.co")
-	fmt.Println("\tpull\t\t Pull remote deck changes from ultradeck.co")
-	fmt.Println("\twatch\t\t Watch for changes either locally or remotely, and keep local + remote in sync")
+	fmt.Println("\tpush\t\t Push local changes to ultradeck.co (--force to overwrite conflicting changes made there)")
+	fmt.Println("\tpull\t\t Pull remote deck changes from ultradeck.co (--force to throw away local changes that haven't been pushed)")
+	fmt.Println("\twatch\t\t Watch for changes either locally or remotely, and keep local + remote in sync (--dashboard for a status view, --direction=up|down to only push or pull).  Give directories to watch several decks at once")
 	fmt.Println("\tpresent\t\t Open the present screen for the deck")
 	fmt.Println("\tedit\t\t Open the edit screen for the deck")
-	fmt.Println("\n")
+	fmt.Print("\n\n")
 
 	fmt.Println("Other commands:")
-	fmt.Println("\tupgrade\t\t A handy link to upgrade your account")
+	fmt.Println("\tupgrade\t\t A handy link to upgrade your account (--print-url to print it instead)")
 	fmt.Println("\tcheck\t\t Check to make sure you're properly authorized with ultradeck.co.")
+	fmt.Println("\twhoami\t\t Show account details and plan usage (--json for scripts)")
+	fmt.Print("\n\n")
+
+	fmt.Println("Global options, given before the command:")
+	fmt.Println("\t--api-url\t Use a different ultradeck API, e.g. a staging or self-hosted instance")
+	fmt.Println("\t--app-url\t Use a different ultradeck web app")
+	fmt.Println("\t--ws-url\t Use a different ultradeck websocket server")
+	fmt.Println("\t--ca-bundle\t Trust the certificate authorities in this PEM file, too")
+	fmt.Println("\t--proxy\t\t Connect through this proxy")
+	fmt.Println("\t--allow-insecure Allow connecting without TLS (http:// and ws:// URLs)")
 }
 
 func (c *Client) dateCompare(d1 string, d2 string) int {
@@ -438,25 +1862,65 @@ func (c *Client) dateCompare(d1 string, d2 string) int {
 	return 1
 }
 
-func (c *Client) processAuthResponse(req *client.Request) {
+// formats usage against a plan limit, where a limit of 0 means unlimited
+func formatUsage(used int64, limit int64, format func(int64) string) string {
+	if limit == 0 {
+		return fmt.Sprintf("%s (unlimited)", format(used))
+	}
+	return fmt.Sprintf("%s of %s", format(used), format(limit))
+}
+
+func formatCount(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+}
+
+func (c *Client) processAuthResponse(conn *client.WebsocketConnection, req *client.Request) (*client.AuthJson, error) {
 	client.DebugMsg("processAuthResponse")
-	writer := client.NewAuthConfig(req.Data)
-	writer.WriteAuth()
-	c.Conn.CloseConnection()
+	defer conn.CloseConnection()
+
+	writer, err := client.NewAuthConfigFromJSON(req.Data)
+	if err != nil {
+		return nil, fmt.Errorf("Something went wrong while authenticating: %s\nPlease run 'ultradeck auth' again.", err)
+	}
+
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	if err := writer.WriteAuth(); err != nil {
+		return nil, err
+	}
+	return writer.AuthJson, nil
 }
 
 func (c *Client) backendURL() string {
-	if os.Getenv("DEV_MODE") != "" {
-		return DevBackendURL
-	} else {
-		return BackendURL
-	}
+	return client.CurrentEndpoints().APIURL
 }
 
 func (c *Client) frontendURL() string {
-	if os.Getenv("DEV_MODE") != "" {
-		return DevFrontendURL
-	} else {
-		return FrontendURL
-	}
+	return client.CurrentEndpoints().FrontendURL
 }
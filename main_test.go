@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gammons/ultradeck-cli/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// a deck directory with two slides, as last synced, and a Client for it
+func newTestDeck(t *testing.T) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "ultradeck-main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deckConfig := &client.DeckConfig{
+		UUID:      "deck-1",
+		Title:     "My Deck",
+		UpdatedAt: "2019-01-01T10:00:00.000Z",
+		Slides: []*client.Slide{
+			{UUID: "slide-1", Position: 1, Markdown: "# One"},
+			{UUID: "slide-2", Position: 2, Markdown: "# Two"},
+		},
+	}
+	data, _ := json.Marshal(deckConfig)
+	ioutil.WriteFile(filepath.Join(dir, ".ud.json"), data, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "deck.md"), []byte("# One\n\n---\n\n# Two"), 0644)
+
+	c := &Client{dir: dir, direction: syncBoth, out: ioutil.Discard}
+	c.ignore = client.LoadIgnoreRulesOrDefault(dir, ioutil.Discard)
+	return c, func() { os.RemoveAll(dir) }
+}
+
+// the pushable events that arrive on watcher within wait
+func pushableEvents(c *Client, watcher *fsnotify.Watcher, wait time.Duration) []fsnotify.Event {
+	var pushable []fsnotify.Event
+	timeout := time.After(wait)
+	for {
+		select {
+		case event := <-watcher.Events:
+			if c.isPushableEvent(event) {
+				pushable = append(pushable, event)
+			}
+		case <-timeout:
+			return pushable
+		}
+	}
+}
+
+func TestPullNeverQueuesAPush(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not really a png"))
+	}))
+	defer server.Close()
+	client.SetEndpoints(&client.Endpoints{APIURL: server.URL, AllowInsecure: true})
+	defer client.SetEndpoints(nil)
+
+	c, cleanup := newTestDeck(t)
+	defer cleanup()
+	os.Mkdir(filepath.Join(c.dir, "images"), 0755)
+
+	watcher, err := fsnotify.NewWatcher()
+	assert.Nil(err)
+	defer watcher.Close()
+	assert.Nil(c.watchDirectory(watcher, c.dir))
+
+	changes := &client.DeckChanges{
+		UpdatedAt: "2019-01-01T11:00:00.000Z",
+		Ops: []*client.SlideOp{
+			{Op: client.UpdateSlideOp, UUID: "slide-2", Position: 2, Slide: &client.Slide{Markdown: "# Two, edited elsewhere"}},
+			{Op: client.AddSlideOp, UUID: "slide-3", Position: 3, Slide: &client.Slide{Markdown: "# Three"}},
+		},
+		Assets: []*client.Asset{{Filename: "images/cat.png", URL: server.URL + "/cat.png"}},
+	}
+	assert.Nil(c.applyRemoteChanges(c.deckConfigManager(), changes))
+
+	markdown, _ := ioutil.ReadFile(filepath.Join(c.dir, "deck.md"))
+	assert.Contains(string(markdown), "# Three")
+	_, err = os.Stat(filepath.Join(c.dir, "images", "cat.png"))
+	assert.Nil(err)
+	assert.Empty(pushableEvents(c, watcher, 500*time.Millisecond), "the pull's own writes")
+
+	// but the user's next edit is pushed
+	ioutil.WriteFile(filepath.Join(c.dir, "deck.md"), []byte("# One"), 0644)
+	assert.NotEmpty(pushableEvents(c, watcher, 500*time.Millisecond))
+}
+
+// an API server that answers with respond, given each request as e.g.
+// "PATCH /api/v1/decks/deck-1/slides".  Returns the requests it got.
+func newTestAPI(t *testing.T, respond func(request string) (int, interface{})) (*[]string, func()) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := r.Method + " " + r.URL.Path
+		requests = append(requests, request)
+		status, body := respond(request)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+	client.SetEndpoints(&client.Endpoints{APIURL: server.URL, AllowInsecure: true})
+	return &requests, func() {
+		server.Close()
+		client.SetEndpoints(nil)
+	}
+}
+
+func TestForcedPushReplacesADeckThatChangedRemotely(t *testing.T) {
+	assert := assert.New(t)
+
+	c, cleanup := newTestDeck(t)
+	defer cleanup()
+	ioutil.WriteFile(filepath.Join(c.dir, "deck.md"), []byte("# One\n\n---\n\n# Two, edited"), 0644)
+
+	requests, stop := newTestAPI(t, func(request string) (int, interface{}) {
+		switch request {
+		case "PATCH /api/v1/decks/deck-1/slides":
+			return http.StatusConflict, map[string]string{"error": "the deck has changed"}
+		case "PUT /api/v1/decks/deck-1":
+			return http.StatusOK, &client.DeckConfig{UUID: "deck-1", UpdatedAt: "2019-01-01T12:00:00.000Z"}
+		}
+		return http.StatusNotFound, nil
+	})
+	defer stop()
+
+	resp := &client.AuthCheckResponse{Token: "token"}
+	assert.True(client.IsConflict(c.pushDeck(resp, false)))
+	assert.Nil(c.pushDeck(resp, true))
+	assert.Equal([]string{
+		"PATCH /api/v1/decks/deck-1/slides",
+		"PATCH /api/v1/decks/deck-1/slides",
+		"PUT /api/v1/decks/deck-1",
+	}, *requests)
+	assert.Equal("2019-01-01T12:00:00.000Z", c.deckConfigManager().DeckConfig.UpdatedAt)
+}
+
+func TestIsPushableEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	c, cleanup := newTestDeck(t)
+	defer cleanup()
+	for _, dir := range []string{"images", "slides", "node_modules"} {
+		os.Mkdir(filepath.Join(c.dir, dir), 0755)
+	}
+	for _, file := range []string{"cat.png", "images/dog.jpg", "slides/intro.md", "notes.txt", "deck.md.swp", "node_modules/logo.png"} {
+		ioutil.WriteFile(filepath.Join(c.dir, filepath.FromSlash(file)), []byte("..."), 0644)
+	}
+
+	tests := []struct {
+		name     string
+		op       fsnotify.Op
+		pushable bool
+	}{
+		{"deck.md", fsnotify.Write, true},
+		{"deck.md", fsnotify.Chmod, false},
+		{"cat.png", fsnotify.Create, true},
+		{"images/dog.jpg", fsnotify.Write, true},
+		{"images/gone.png", fsnotify.Remove, true},
+		{"images", fsnotify.Create, true},
+		{"images", fsnotify.Write, false},
+		{"old-images", fsnotify.Remove, true},
+
+		// included Markdown isn't a thing, so drafts are left alone
+		{"slides/intro.md", fsnotify.Write, false},
+		{"slides/intro.md", fsnotify.Create, false},
+		{"notes.txt", fsnotify.Write, false},
+		{"draft.md", fsnotify.Remove, false},
+
+		{".ud.json", fsnotify.Write, false},
+		{client.JournalFile, fsnotify.Create, false},
+		{"deck.md.swp", fsnotify.Write, false},
+		{"node_modules/logo.png", fsnotify.Create, false},
+	}
+	for _, test := range tests {
+		event := fsnotify.Event{Name: filepath.Join(c.dir, filepath.FromSlash(test.name)), Op: test.op}
+		assert.Equal(test.pushable, c.isPushableEvent(event), "%s %s", test.op, test.name)
+	}
+}
+
+func TestSyncQueueCoalescesChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	release := make(chan bool)
+	var tokens []string
+	q := newSyncQueue(func(resp *client.AuthCheckResponse) error {
+		tokens = append(tokens, resp.Token)
+		<-release
+		return nil
+	})
+	defer q.timer.Stop()
+
+	resp := &client.AuthCheckResponse{Token: "first"}
+	q.start(resp)
+	assert.False(q.running, "nothing changed")
+
+	q.changed()
+	q.changed()
+	q.changed()
+	select {
+	case <-q.timer.C:
+	case <-time.After(2 * pushDebounce):
+		t.Fatal("the timer never fired")
+	}
+	q.start(resp)
+	assert.True(q.running)
+
+	// changes while the run is in flight wait for it, with the new token
+	resp.Token = "second"
+	q.changed()
+	q.start(resp)
+	release <- true
+	q.finished(<-q.done)
+	assert.True(q.dirty)
+
+	q.start(resp)
+	release <- true
+	q.finished(<-q.done)
+	assert.False(q.dirty)
+	assert.Equal([]string{"first", "second"}, tokens)
+}
+
+func TestSyncQueueFlush(t *testing.T) {
+	errOffline := errors.New("offline")
+
+	tests := []struct {
+		name     string
+		inFlight bool
+		changed  bool
+		results  []error
+		out      string
+		err      error
+		dirty    bool
+	}{
+		{"nothing changed", false, false, nil, "", nil, false},
+		{"a change", false, true, []error{nil}, "Pushing your last changes...\n", nil, false},
+		{"a change during a run", true, true, []error{nil, nil}, "Pushing your last changes...\n", nil, false},
+		{"a failed run", true, false, []error{errOffline, nil}, "push failed: offline\nPushing your last changes...\n", nil, false},
+		{"a failed flush", false, true, []error{errOffline}, "Pushing your last changes...\n", errOffline, true},
+	}
+	for _, test := range tests {
+		runs := 0
+		q := newSyncQueue(func(resp *client.AuthCheckResponse) error {
+			runs++
+			return test.results[runs-1]
+		})
+		resp := &client.AuthCheckResponse{Token: "token"}
+		if test.inFlight {
+			q.changed()
+			q.start(resp)
+		}
+		if test.changed {
+			q.changed()
+		}
+
+		var out bytes.Buffer
+		err := q.flush(resp, &out)
+		assert.Equal(t, test.err, err, test.name)
+		assert.Equal(t, test.out, out.String(), test.name)
+		assert.Equal(t, test.dirty, q.dirty, test.name)
+		assert.Equal(t, len(test.results), runs, test.name)
+	}
+}
+
+func TestRemoteChangesAndDirection(t *testing.T) {
+	tests := []struct {
+		direction string
+		journal   bool
+		clientID  string
+		push      bool
+		pull      bool
+	}{
+		{syncBoth, false, "someone-else", false, true},
+		{syncBoth, false, "me", false, false},
+		// pushing the journal brings in the remote changes too
+		{syncBoth, true, "someone-else", true, false},
+		{syncDown, true, "someone-else", false, true},
+		{syncUp, false, "someone-else", false, false},
+	}
+	for _, test := range tests {
+		c, cleanup := newTestDeck(t)
+		c.ClientID = "me"
+		c.direction = test.direction
+		c.pushes = newSyncQueue(nil)
+		c.pulls = newSyncQueue(nil)
+		if test.journal {
+			journal, _ := client.ReadJournal(c.dir)
+			journal.Append("2019-01-01T10:00:00.000Z", []*client.SlideOp{{Op: client.UpdateSlideOp, UUID: "slide-2"}})
+			assert.Nil(t, journal.Write())
+		}
+
+		var handlers client.MessageHandlers
+		c.registerWatchHandlers(&handlers)
+		req, _ := client.NewRequest(client.DeckUpdatedMessage, test.clientID, "channel", &client.DeckUpdated{DeckUUID: "deck-1"})
+		assert.Nil(t, handlers.Dispatch(req))
+
+		name := fmt.Sprintf("--direction=%s, journal %t, from %s", test.direction, test.journal, test.clientID)
+		assert.Equal(t, test.push, c.pushes.dirty, name)
+		assert.Equal(t, test.pull, c.pulls.dirty, name)
+
+		c.pushes.timer.Stop()
+		c.pulls.timer.Stop()
+		cleanup()
+	}
+}
+
+func TestPushReplaysTheJournal(t *testing.T) {
+	changedElsewhere := &client.DeckChanges{
+		UpdatedAt: "2019-01-01T11:00:00.000Z",
+		Ops:       []*client.SlideOp{{Op: client.UpdateSlideOp, UUID: "slide-2", Position: 2, Slide: &client.Slide{Markdown: "# Two, edited elsewhere"}}},
+	}
+	unchanged := &client.DeckChanges{UpdatedAt: "2019-01-01T10:00:00.000Z"}
+	patched := &client.DeckChanges{UpdatedAt: "2019-01-01T12:00:00.000Z"}
+
+	tests := []struct {
+		name      string
+		direction string
+		remote    *client.DeckChanges
+		patch     int
+		requests  []string
+		conflict  bool
+		journaled bool
+	}{
+		{"back online", syncBoth, unchanged, http.StatusOK, []string{
+			"GET /api/v1/decks/deck-1/changes",
+			"PATCH /api/v1/decks/deck-1/slides",
+		}, false, false},
+		{"changed elsewhere", syncBoth, changedElsewhere, http.StatusConflict, []string{
+			"GET /api/v1/decks/deck-1/changes",
+		}, true, true},
+		// --direction=up doesn't look, and replaces the deck
+		{"changed elsewhere, pushing up", syncUp, changedElsewhere, http.StatusConflict, []string{
+			"PATCH /api/v1/decks/deck-1/slides",
+			"PUT /api/v1/decks/deck-1",
+		}, false, false},
+	}
+	for _, test := range tests {
+		c, cleanup := newTestDeck(t)
+		c.direction = test.direction
+		resp := &client.AuthCheckResponse{Token: "token"}
+
+		// edited while ultradeck.co can't be reached
+		offline := httptest.NewServer(http.NotFoundHandler())
+		offline.Close()
+		client.SetEndpoints(&client.Endpoints{APIURL: offline.URL, AllowInsecure: true})
+		ioutil.WriteFile(filepath.Join(c.dir, "deck.md"), []byte("# One\n\n---\n\n# Two, edited offline"), 0644)
+		err := c.pushDeck(resp, false)
+		assert.IsType(t, &offlineError{}, err, test.name)
+		assert.True(t, c.journalPending(), test.name)
+		assert.Equal(t, "# Two, edited offline", c.deckConfigManager().DeckConfig.Slides[1].Markdown, test.name)
+
+		requests, stop := newTestAPI(t, func(request string) (int, interface{}) {
+			switch request {
+			case "GET /api/v1/decks/deck-1/changes":
+				return http.StatusOK, test.remote
+			case "PATCH /api/v1/decks/deck-1/slides":
+				if test.patch != http.StatusOK {
+					return test.patch, map[string]string{"error": "the deck has changed"}
+				}
+				return http.StatusOK, patched
+			case "PUT /api/v1/decks/deck-1":
+				return http.StatusOK, &client.DeckConfig{UUID: "deck-1", UpdatedAt: "2019-01-01T12:00:00.000Z"}
+			}
+			return http.StatusNotFound, nil
+		})
+		err = c.pushDeck(resp, false)
+		stop()
+
+		_, isConflict := err.(*client.JournalConflictError)
+		assert.Equal(t, test.conflict, isConflict, "%s: %v", test.name, err)
+		assert.Equal(t, test.requests, *requests, test.name)
+		assert.Equal(t, test.journaled, c.journalPending(), test.name)
+		cleanup()
+	}
+}
+
+func TestRouteMessagesByDeckUUID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &Client{Conn: &client.WebsocketConnection{}}
+	decks := []*watchedDeck{
+		{uuid: "deck-1", requests: make(chan *client.Request, 10)},
+		{uuid: "deck-2", requests: make(chan *client.Request, 10)},
+	}
+	c.routeMessages(ctx, decks)
+
+	tests := []struct {
+		kind client.MessageKind
+		data interface{}
+		to   []string
+	}{
+		{client.DeckUpdatedMessage, &client.DeckUpdated{DeckUUID: "deck-1"}, []string{"deck-1"}},
+		{client.PresenceMessage, &client.Presence{DeckUUID: "deck-2"}, []string{"deck-2"}},
+		{client.DeckUpdatedMessage, &client.DeckUpdated{DeckUUID: "deck-3"}, nil},
+		// older servers don't say which deck
+		{client.DeckUpdatedMessage, nil, []string{"deck-1", "deck-2"}},
+		{client.PongMessage, nil, nil},
+	}
+	for _, test := range tests {
+		req, _ := client.NewRequest(test.kind, "someone-else", "channel", test.data)
+		assert.Nil(t, c.Conn.Dispatch(req))
+
+		var to []string
+		for _, deck := range decks {
+			select {
+			case routed := <-deck.requests:
+				assert.Equal(t, req, routed)
+				to = append(to, deck.uuid)
+			default:
+			}
+		}
+		assert.Equal(t, test.to, to, "%s %v", test.kind, test.data)
+	}
+}